@@ -0,0 +1,59 @@
+package libbadger
+
+import (
+	"context"
+
+	"github.com/dgraph-io/badger/v3"
+	libhttp "github.com/otamoe/go-library/http"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type (
+	// ACME 证书 和 账户私钥 缓存
+	ACMECache struct {
+		db     *badger.DB
+		prefix []byte
+	}
+)
+
+func NewACMECache(db *badger.DB, prefix []byte) *ACMECache {
+	return &ACMECache{db: db, prefix: prefix}
+}
+
+func (cache *ACMECache) key(name string) []byte {
+	return append(append([]byte{}, cache.prefix...), name...)
+}
+
+func (cache *ACMECache) Get(ctx context.Context, name string) (data []byte, err error) {
+	err = cache.db.View(func(txn *badger.Txn) (err error) {
+		var item *badger.Item
+		if item, err = txn.Get(cache.key(name)); err != nil {
+			return
+		}
+		data, err = item.ValueCopy(nil)
+		return
+	})
+	if err == badger.ErrKeyNotFound {
+		err = autocert.ErrCacheMiss
+	}
+	return
+}
+
+func (cache *ACMECache) Put(ctx context.Context, name string, data []byte) error {
+	return cache.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(cache.key(name), data)
+	})
+}
+
+func (cache *ACMECache) Delete(ctx context.Context, name string) error {
+	return cache.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(cache.key(name))
+	})
+}
+
+// 证书缓存在 badger 其他配置 从 http.acme.* 读取
+func WithACME() func(db *badger.DB) (out libhttp.OutOption) {
+	return func(db *badger.DB) (out libhttp.OutOption) {
+		return libhttp.WithACME(libhttp.NewACME(NewACMECache(db, []byte("acme/"))))()
+	}
+}
@@ -0,0 +1,330 @@
+package libbadger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	BackupManifest struct {
+		Backups []BackupEntry `json:"backups"`
+	}
+
+	BackupEntry struct {
+		Name      string    `json:"name"`
+		Full      bool      `json:"full"`
+		Since     uint64    `json:"since"`
+		Version   uint64    `json:"version"`
+		Size      int64     `json:"size"`
+		CreatedAt time.Time `json:"createdAt"`
+	}
+)
+
+const BackupManifestName = "manifest.json"
+const BackupLockName = "backup.lock"
+
+var ErrBackupNotFound = errors.New("badger backup not found")
+var ErrRestoreNotEmpty = errors.New("badger restore directory is not empty")
+var ErrBackupLocked = errors.New("badger backup directory is locked by another backup")
+
+func WithBackup(dir string, interval time.Duration, full int, keep int) func() (out OutExtendedOption) {
+	return func() (out OutExtendedOption) {
+		out.Option = BackupOption(dir, interval, full, keep)
+		return
+	}
+}
+
+func BackupOption(dir string, interval time.Duration, full int, keep int) ExtendedOption {
+	return func(extendedOptions *ExtendedOptions) (err error) {
+		extendedOptions.BackupDir = dir
+		extendedOptions.BackupInterval = interval
+		extendedOptions.BackupFull = full
+		extendedOptions.BackupKeep = keep
+		return
+	}
+}
+
+func Backup(ctx context.Context, extendedOptions *ExtendedOptions, db *badger.DB) {
+	t := time.NewTicker(extendedOptions.BackupInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if db.IsClosed() {
+				return
+			}
+			if _, err := BackupOnce(db, extendedOptions.BackupDir, extendedOptions.BackupFull); err != nil {
+				db.Opts().Logger.Errorf("error during a backup %s", err)
+				continue
+			}
+			if err := RotateBackup(extendedOptions.BackupDir, extendedOptions.BackupKeep); err != nil {
+				db.Opts().Logger.Errorf("error during a backup rotation %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 备份一次 没有全量备份 或 增量备份次数达到 full 时 做全量备份 同一目录 同时只能有一个备份
+func BackupOnce(db *badger.DB, dir string, full int) (entry BackupEntry, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = lockBackupDir(dir); err != nil {
+		return
+	}
+	defer unlock()
+
+	// 持有目录锁 临时文件 都是 进程退出时 未完成的备份
+	if files, e := ioutil.ReadDir(dir); e == nil {
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".backup.tmp") {
+				os.Remove(path.Join(dir, file.Name()))
+			}
+		}
+	}
+
+	var manifest *BackupManifest
+	if manifest, err = ReadBackupManifest(dir); err != nil {
+		return
+	}
+
+	// 增量次数
+	incremental := -1
+	for i := len(manifest.Backups) - 1; i >= 0; i-- {
+		if manifest.Backups[i].Full {
+			incremental = len(manifest.Backups) - 1 - i
+			break
+		}
+	}
+
+	entry.CreatedAt = time.Now().UTC()
+	entry.Full = incremental == -1 || (full > 0 && incremental >= full)
+	if !entry.Full {
+		// Since 为 增量包含的 最小版本
+		entry.Since = manifest.Backups[len(manifest.Backups)-1].Version + 1
+	}
+	if entry.Full {
+		entry.Name = fmt.Sprintf("full-%d.backup", entry.CreatedAt.UnixNano())
+	} else {
+		entry.Name = fmt.Sprintf("incremental-%d.backup", entry.CreatedAt.UnixNano())
+	}
+
+	// 先写入临时文件
+	tmp := path.Join(dir, entry.Name+".tmp")
+	var f *os.File
+	if f, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+		return
+	}
+	defer os.Remove(tmp)
+
+	// badger v3 的 IteratorOptions.SinceTs 只读取 版本大于 SinceTs 的数据
+	var sinceTs uint64
+	if !entry.Full {
+		sinceTs = entry.Since - 1
+	}
+	w := bufio.NewWriterSize(f, 1024*64)
+	if entry.Version, err = db.Backup(w, sinceTs); err == nil {
+		if err = w.Flush(); err == nil {
+			err = f.Sync()
+		}
+	}
+	if e := f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+
+	// 增量没有新数据
+	if !entry.Full && entry.Version < entry.Since {
+		entry.Version = entry.Since - 1
+		return
+	}
+
+	var stat os.FileInfo
+	if stat, err = os.Stat(tmp); err != nil {
+		return
+	}
+	entry.Size = stat.Size()
+
+	if err = os.Rename(tmp, path.Join(dir, entry.Name)); err != nil {
+		return
+	}
+
+	manifest.Backups = append(manifest.Backups, entry)
+	err = manifest.write(dir)
+	return
+}
+
+// 只保留最近 keep 个全量备份链
+func RotateBackup(dir string, keep int) (err error) {
+	if keep <= 0 {
+		return
+	}
+	var unlock func()
+	if unlock, err = lockBackupDir(dir); err != nil {
+		return
+	}
+	defer unlock()
+
+	var manifest *BackupManifest
+	if manifest, err = ReadBackupManifest(dir); err != nil {
+		return
+	}
+
+	start := 0
+	fulls := 0
+	for i := len(manifest.Backups) - 1; i >= 0; i-- {
+		if manifest.Backups[i].Full {
+			fulls++
+			if fulls == keep {
+				start = i
+				break
+			}
+		}
+	}
+	if start == 0 {
+		return
+	}
+
+	removes := manifest.Backups[0:start]
+	manifest.Backups = append([]BackupEntry{}, manifest.Backups[start:]...)
+	if err = manifest.write(dir); err != nil {
+		return
+	}
+	for _, entry := range removes {
+		if e := os.Remove(path.Join(dir, entry.Name)); e != nil && !os.IsNotExist(e) && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// 从备份恢复数据库 until 为空时恢复到最新的备份 必须在 NewBadger 打开数据库之前执行
+func Restore(dir string, until time.Time, options badger.Options) (err error) {
+	var manifest *BackupManifest
+	if manifest, err = ReadBackupManifest(dir); err != nil {
+		return
+	}
+
+	// 全量备份 + 之后的增量备份
+	var entries []BackupEntry
+	for _, entry := range manifest.Backups {
+		if !until.IsZero() && entry.CreatedAt.After(until) {
+			break
+		}
+		if entry.Full {
+			entries = []BackupEntry{entry}
+		} else if len(entries) != 0 {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		err = ErrBackupNotFound
+		return
+	}
+
+	for _, d := range []string{options.Dir, options.ValueDir} {
+		if d == "" {
+			continue
+		}
+		var files []os.FileInfo
+		if files, err = ioutil.ReadDir(d); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+		if len(files) != 0 {
+			err = ErrRestoreNotEmpty
+			return
+		}
+		if err = os.MkdirAll(d, 0755); err != nil {
+			return
+		}
+	}
+
+	var db *badger.DB
+	if db, err = badger.Open(options); err != nil {
+		return
+	}
+	defer func() {
+		if e := db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	for _, entry := range entries {
+		if err = restoreEntry(db, path.Join(dir, entry.Name)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func restoreEntry(db *badger.DB, name string) (err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+	return db.Load(f, 256)
+}
+
+// 非阻塞 文件锁 进程退出时 自动释放
+func lockBackupDir(dir string) (unlock func(), err error) {
+	var f *os.File
+	if f, err = os.OpenFile(path.Join(dir, BackupLockName), os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			err = ErrBackupLocked
+		}
+		return
+	}
+	unlock = func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}
+	return
+}
+
+// 读取备份清单 不存在时返回空清单
+func ReadBackupManifest(dir string) (manifest *BackupManifest, err error) {
+	manifest = &BackupManifest{}
+	var b []byte
+	if b, err = ioutil.ReadFile(path.Join(dir, BackupManifestName)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(b, manifest)
+	return
+}
+
+func (manifest *BackupManifest) write(dir string) (err error) {
+	var b []byte
+	if b, err = json.MarshalIndent(manifest, "", "  "); err != nil {
+		return
+	}
+	tmp := path.Join(dir, BackupManifestName+".tmp")
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return
+	}
+	return os.Rename(tmp, path.Join(dir, BackupManifestName))
+}
@@ -9,9 +9,14 @@ func New() fx.Option {
 		fx.Provide(NewOptions),
 		fx.Provide(NewExtendedOption),
 		fx.Provide(ViperLoggerLevel),
+		fx.Provide(ViperEncryption),
 
 		fx.Provide(Logger),
 
 		fx.Provide(NewBadger),
+		fx.Provide(NewFeed),
+		fx.Provide(NewGCController),
+		fx.Invoke(func(*GCController) {}),
+		fx.Provide(HealthChecker),
 	)
 }
@@ -0,0 +1,243 @@
+package collection
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	Collection[T any] struct {
+		db      *badger.DB
+		prefix  []byte
+		options Options
+
+		// Update 持有读锁 直到提交 添加索引 和 切换索引版本 持有写锁
+		indexMux sync.RWMutex
+		indexes  []*Index[T]
+		// 持有写锁时 更新的 []indexState[T] View 读取 不加锁
+		states atomic.Value
+	}
+
+	Options struct {
+		Codec      Codec
+		TTL        time.Duration
+		MaxRetries int
+		RetrySleep time.Duration
+	}
+
+	Option func(options *Options) error
+
+	Txn[T any] struct {
+		collection *Collection[T]
+		txn        *badger.Txn
+		indexes    []indexState[T]
+	}
+)
+
+var ErrNotFound = badger.ErrKeyNotFound
+var ErrEmptyPrefix = errors.New("collection prefix is empty")
+var ErrInvalidPrefix = errors.New("collection prefix contains :")
+
+func WithCodec(codec Codec) Option {
+	return func(options *Options) error {
+		options.Codec = codec
+		return nil
+	}
+}
+
+// 默认的过期时间 0 不过期
+func WithTTL(ttl time.Duration) Option {
+	return func(options *Options) error {
+		options.TTL = ttl
+		return nil
+	}
+}
+
+// badger.ErrConflict 时的重试次数
+func WithRetry(maxRetries int, sleep time.Duration) Option {
+	return func(options *Options) error {
+		options.MaxRetries = maxRetries
+		options.RetrySleep = sleep
+		return nil
+	}
+}
+
+func New[T any](db *badger.DB, prefix string, opts ...Option) (collection *Collection[T], err error) {
+	if prefix == "" {
+		err = ErrEmptyPrefix
+		return
+	}
+	// 前缀 不能嵌套 例如 a 的 d:{key} 和 a:d 的 key
+	if strings.Contains(prefix, ":") {
+		err = ErrInvalidPrefix
+		return
+	}
+	collection = &Collection[T]{
+		db:     db,
+		prefix: []byte(prefix + ":"),
+		options: Options{
+			Codec:      JSONCodec{},
+			MaxRetries: 10,
+			RetrySleep: time.Millisecond * 10,
+		},
+	}
+	for _, o := range opts {
+		if err = o(&collection.options); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (collection *Collection[T]) DB() *badger.DB {
+	return collection.db
+}
+
+// 读写事务 冲突时自动重试 fn 中不能再调用 Update 可以调用 View
+func (collection *Collection[T]) Update(fn func(txn *Txn[T]) error) (err error) {
+	collection.indexMux.RLock()
+	defer collection.indexMux.RUnlock()
+	indexes := collection.indexStates()
+	for i := 0; ; i++ {
+		err = collection.db.Update(func(txn *badger.Txn) error {
+			return fn(&Txn[T]{collection: collection, txn: txn, indexes: indexes})
+		})
+		if err != badger.ErrConflict || i >= collection.options.MaxRetries {
+			return
+		}
+		if collection.options.RetrySleep != 0 {
+			time.Sleep(collection.options.RetrySleep + time.Duration(rand.Int63n(int64(collection.options.RetrySleep))))
+		}
+	}
+}
+
+// 只读事务 不持有 indexMux Update 的 fn 中 可以调用
+func (collection *Collection[T]) View(fn func(txn *Txn[T]) error) (err error) {
+	indexes, _ := collection.states.Load().([]indexState[T])
+	return collection.db.View(func(txn *badger.Txn) error {
+		return fn(&Txn[T]{collection: collection, txn: txn, indexes: indexes})
+	})
+}
+
+func (collection *Collection[T]) Get(key []byte) (value T, err error) {
+	err = collection.View(func(txn *Txn[T]) (err error) {
+		value, err = txn.Get(key)
+		return
+	})
+	return
+}
+
+func (collection *Collection[T]) Put(key []byte, value T) (err error) {
+	return collection.PutWithTTL(key, value, collection.options.TTL)
+}
+
+func (collection *Collection[T]) PutWithTTL(key []byte, value T, ttl time.Duration) (err error) {
+	return collection.Update(func(txn *Txn[T]) error {
+		return txn.PutWithTTL(key, value, ttl)
+	})
+}
+
+func (collection *Collection[T]) Delete(key []byte) (err error) {
+	return collection.Update(func(txn *Txn[T]) error {
+		return txn.Delete(key)
+	})
+}
+
+func (collection *Collection[T]) Scan(options ScanOptions) (items []Item[T], cursor []byte, err error) {
+	err = collection.View(func(txn *Txn[T]) (err error) {
+		items, cursor, err = txn.Scan(options)
+		return
+	})
+	return
+}
+
+func (collection *Collection[T]) Each(options ScanOptions, fn func(item Item[T]) error) (err error) {
+	return collection.View(func(txn *Txn[T]) error {
+		return txn.Each(options, fn)
+	})
+}
+
+func (collection *Collection[T]) dataKey(key []byte) []byte {
+	b := make([]byte, 0, len(collection.prefix)+2+len(key))
+	b = append(b, collection.prefix...)
+	b = append(b, 'd', ':')
+	return append(b, key...)
+}
+
+func (collection *Collection[T]) decode(data []byte) (value T, err error) {
+	err = collection.options.Codec.Unmarshal(data, &value)
+	return
+}
+
+func (txn *Txn[T]) Badger() *badger.Txn {
+	return txn.txn
+}
+
+func (txn *Txn[T]) Get(key []byte) (value T, err error) {
+	var item *badger.Item
+	if item, err = txn.txn.Get(txn.collection.dataKey(key)); err != nil {
+		return
+	}
+	err = item.Value(func(val []byte) (err error) {
+		value, err = txn.collection.decode(val)
+		return
+	})
+	return
+}
+
+func (txn *Txn[T]) Put(key []byte, value T) (err error) {
+	return txn.PutWithTTL(key, value, txn.collection.options.TTL)
+}
+
+func (txn *Txn[T]) PutWithTTL(key []byte, value T, ttl time.Duration) (err error) {
+	var data []byte
+	if data, err = txn.collection.options.Codec.Marshal(value); err != nil {
+		return
+	}
+	if len(txn.indexes) != 0 {
+		var old *T
+		if old, err = txn.old(key); err != nil {
+			return
+		}
+		if err = txn.putIndexes(key, old, &value, ttl); err != nil {
+			return
+		}
+	}
+	entry := badger.NewEntry(txn.collection.dataKey(key), data)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return txn.txn.SetEntry(entry)
+}
+
+func (txn *Txn[T]) Delete(key []byte) (err error) {
+	if len(txn.indexes) != 0 {
+		var old *T
+		if old, err = txn.old(key); err != nil {
+			return
+		}
+		if err = txn.putIndexes(key, old, nil, 0); err != nil {
+			return
+		}
+	}
+	return txn.txn.Delete(txn.collection.dataKey(key))
+}
+
+// 读取旧值 不存在时返回 nil
+func (txn *Txn[T]) old(key []byte) (old *T, err error) {
+	var value T
+	if value, err = txn.Get(key); err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	old = &value
+	return
+}
@@ -0,0 +1,511 @@
+package collection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	// Keys 返回 value 的索引值 返回空时不建立索引
+	Index[T any] struct {
+		Name   string
+		Unique bool
+		Keys   func(value T) [][]byte
+
+		// 持有 collection.indexMux 写锁时 修改
+		generation uint64
+		building   *indexBuild
+	}
+
+	UniqueError struct {
+		Index string
+		Value []byte
+		Key   []byte
+	}
+
+	// 重建中的 影子版本
+	indexBuild struct {
+		generation uint64
+
+		mux sync.Mutex
+		err error
+	}
+
+	// 事务开始时 索引的版本
+	indexState[T any] struct {
+		index      *Index[T]
+		generation uint64
+		building   *indexBuild
+	}
+)
+
+var ErrUniqueViolation = errors.New("collection unique index violation")
+var ErrIndexNotFound = errors.New("collection index not found")
+var ErrIndexExists = errors.New("collection index already exists")
+var ErrIndexBuilding = errors.New("collection index is rebuilding")
+var ErrInvalidIndexName = errors.New("collection index name is empty or contains :")
+
+func (err *UniqueError) Error() string {
+	return fmt.Sprintf("collection unique index %s violation: value %q already used by key %q", err.Index, err.Value, err.Key)
+}
+
+func (err *UniqueError) Is(target error) bool {
+	return target == ErrUniqueViolation
+}
+
+// 添加索引 已有数据需要调用 RebuildIndex 会等待 进行中的 Update 结束
+func (collection *Collection[T]) AddIndex(index Index[T]) (err error) {
+	if index.Name == "" || strings.Contains(index.Name, ":") {
+		return ErrInvalidIndexName
+	}
+	if index.Keys == nil {
+		return errors.New("collection index keys is empty")
+	}
+	collection.indexMux.Lock()
+	defer collection.indexMux.Unlock()
+	for _, val := range collection.indexes {
+		if val.Name == index.Name {
+			return ErrIndexExists
+		}
+	}
+	index.building = nil
+	if index.generation, err = collection.indexGeneration(index.Name); err != nil {
+		return
+	}
+	collection.indexes = append(collection.indexes, &index)
+	collection.storeStates()
+	return
+}
+
+// 持有 indexMux 时调用
+func (collection *Collection[T]) indexStates() (states []indexState[T]) {
+	for _, index := range collection.indexes {
+		states = append(states, indexState[T]{index: index, generation: index.generation, building: index.building})
+	}
+	return
+}
+
+// 持有 indexMux 写锁时 修改索引后调用
+func (collection *Collection[T]) storeStates() {
+	collection.states.Store(collection.indexStates())
+}
+
+func (collection *Collection[T]) index(name string) (*Index[T], error) {
+	for _, index := range collection.indexes {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+	return nil, ErrIndexNotFound
+}
+
+func (txn *Txn[T]) index(name string) (state indexState[T], err error) {
+	for _, state = range txn.indexes {
+		if state.index.Name == name {
+			return
+		}
+	}
+	err = ErrIndexNotFound
+	return
+}
+
+// 版本 0 为 i:{name}: 重建后 为 g:{name}:{generation}
+func (collection *Collection[T]) indexPrefix(name string, generation uint64) []byte {
+	b := make([]byte, 0, len(collection.prefix)+len(name)+12)
+	b = append(b, collection.prefix...)
+	if generation == 0 {
+		b = append(b, 'i', ':')
+		b = append(b, name...)
+		return append(b, ':')
+	}
+	b = append(b, 'g', ':')
+	b = append(b, name...)
+	b = append(b, ':')
+	return binary.BigEndian.AppendUint64(b, generation)
+}
+
+// 当前版本 重建完成时 写入
+func (collection *Collection[T]) generationKey(name string) []byte {
+	b := make([]byte, 0, len(collection.prefix)+len(name)+2)
+	b = append(b, collection.prefix...)
+	b = append(b, 'm', ':')
+	return append(b, name...)
+}
+
+func (collection *Collection[T]) indexGeneration(name string) (generation uint64, err error) {
+	err = collection.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(collection.generationKey(name))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) == 8 {
+				generation = binary.BigEndian.Uint64(val)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// 唯一索引 key 不带主键 非唯一索引 key 带主键
+func (collection *Collection[T]) indexKey(index *Index[T], generation uint64, value []byte, key []byte) []byte {
+	b := append(collection.indexPrefix(index.Name, generation), encodeIndexValue(value, true)...)
+	if !index.Unique {
+		b = append(b, key...)
+	}
+	return b
+}
+
+func (collection *Collection[T]) Lookup(name string, value []byte) (items []Item[T], err error) {
+	err = collection.View(func(txn *Txn[T]) (err error) {
+		items, err = txn.Lookup(name, value)
+		return
+	})
+	return
+}
+
+func (collection *Collection[T]) ScanIndex(name string, options ScanOptions) (items []Item[T], cursor []byte, err error) {
+	err = collection.View(func(txn *Txn[T]) (err error) {
+		items, cursor, err = txn.ScanIndex(name, options)
+		return
+	})
+	return
+}
+
+// 在线重建索引 写入新版本 期间 查询 和 唯一约束 仍使用旧版本 写入同时更新两个版本
+// 全部完成后 切换到新版本 再删除旧版本 唯一约束冲突时 放弃新版本 旧版本不受影响
+func (collection *Collection[T]) RebuildIndex(name string, batch int) (err error) {
+	if batch <= 0 {
+		batch = 1000
+	}
+	collection.indexMux.RLock()
+	index, err := collection.index(name)
+	var generation uint64
+	if err == nil {
+		if index.building != nil {
+			err = ErrIndexBuilding
+		}
+		generation = index.generation + 1
+	}
+	collection.indexMux.RUnlock()
+	if err != nil {
+		return
+	}
+
+	// 清理 上次中断 遗留的 新版本
+	shadow := collection.indexPrefix(name, generation)
+	if err = collection.deletePrefix(shadow, batch); err != nil {
+		return
+	}
+
+	// 写锁 等待 进行中的 Update 之后的 Update 同时写入新版本
+	build := &indexBuild{generation: generation}
+	collection.indexMux.Lock()
+	if index.building != nil {
+		collection.indexMux.Unlock()
+		return ErrIndexBuilding
+	}
+	index.building = build
+	collection.storeStates()
+	collection.indexMux.Unlock()
+
+	if err = collection.backfillIndex(index, build, batch); err == nil {
+		build.mux.Lock()
+		err = build.err
+		build.mux.Unlock()
+	}
+	if err == nil {
+		// 切换 写锁期间 没有进行中的 Update
+		collection.indexMux.Lock()
+		build.mux.Lock()
+		err = build.err
+		build.mux.Unlock()
+		if err == nil {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, generation)
+			err = collection.db.Update(func(txn *badger.Txn) error {
+				return txn.Set(collection.generationKey(name), b)
+			})
+		}
+		old := index.generation
+		if err == nil {
+			index.generation = generation
+		}
+		index.building = nil
+		collection.storeStates()
+		collection.indexMux.Unlock()
+		if err == nil {
+			return collection.deletePrefix(collection.indexPrefix(name, old), batch)
+		}
+	} else {
+		collection.indexMux.Lock()
+		index.building = nil
+		collection.storeStates()
+		collection.indexMux.Unlock()
+	}
+	if e := collection.deletePrefix(shadow, batch); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+// 每批 batch 条数据一个事务 写入新版本
+func (collection *Collection[T]) backfillIndex(index *Index[T], build *indexBuild, batch int) (err error) {
+	var cursor []byte
+	for {
+		var keys [][]byte
+		if err = collection.View(func(txn *Txn[T]) error {
+			var items []Item[T]
+			var e error
+			items, cursor, e = txn.Scan(ScanOptions{Cursor: cursor, Limit: batch})
+			for _, item := range items {
+				keys = append(keys, item.Key)
+			}
+			return e
+		}); err != nil {
+			return
+		}
+		if err = collection.Update(func(txn *Txn[T]) (err error) {
+			for _, key := range keys {
+				var item *badger.Item
+				if item, err = txn.txn.Get(collection.dataKey(key)); err == badger.ErrKeyNotFound {
+					err = nil
+					continue
+				} else if err != nil {
+					return
+				}
+				var value T
+				if err = item.Value(func(val []byte) (err error) {
+					value, err = collection.decode(val)
+					return
+				}); err != nil {
+					return
+				}
+				if err = txn.putIndex(index, build.generation, key, nil, index.Keys(value), item.ExpiresAt()); err != nil {
+					return
+				}
+			}
+			return
+		}); err != nil {
+			return
+		}
+		if cursor == nil {
+			return
+		}
+	}
+}
+
+// 每批 batch 个 key 一个事务
+func (collection *Collection[T]) deletePrefix(prefix []byte, batch int) (err error) {
+	for {
+		var keys [][]byte
+		if err = collection.db.View(func(txn *badger.Txn) error {
+			iteratorOptions := badger.DefaultIteratorOptions
+			iteratorOptions.PrefetchValues = false
+			iteratorOptions.Prefix = prefix
+			iterator := txn.NewIterator(iteratorOptions)
+			defer iterator.Close()
+			for iterator.Rewind(); iterator.Valid() && len(keys) < batch; iterator.Next() {
+				keys = append(keys, iterator.Item().KeyCopy(nil))
+			}
+			return nil
+		}); err != nil {
+			return
+		}
+		if len(keys) == 0 {
+			return
+		}
+		if err = collection.db.Update(func(txn *badger.Txn) (err error) {
+			for _, key := range keys {
+				if err = txn.Delete(key); err != nil {
+					return
+				}
+			}
+			return
+		}); err != nil {
+			return
+		}
+	}
+}
+
+// 精确查找
+func (txn *Txn[T]) Lookup(name string, value []byte) (items []Item[T], err error) {
+	var state indexState[T]
+	if state, err = txn.index(name); err != nil {
+		return
+	}
+	base := append(txn.collection.indexPrefix(name, state.generation), encodeIndexValue(value, true)...)
+	err = txn.eachIndex(base, ScanOptions{}, func(_ []byte, item Item[T]) error {
+		items = append(items, item)
+		return nil
+	})
+	return
+}
+
+// 按索引值范围读取一页 Prefix Start End 为原始索引值
+func (txn *Txn[T]) ScanIndex(name string, options ScanOptions) (items []Item[T], cursor []byte, err error) {
+	var state indexState[T]
+	if state, err = txn.index(name); err != nil {
+		return
+	}
+	limit := options.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	if options.Prefix != nil {
+		options.Prefix = encodeIndexValue(options.Prefix, false)
+	}
+	if options.Start != nil {
+		options.Start = encodeIndexValue(options.Start, false)
+	}
+	if options.End != nil {
+		options.End = encodeIndexValue(options.End, false)
+	}
+
+	var last []byte
+	err = txn.eachIndex(txn.collection.indexPrefix(name, state.generation), options, func(key []byte, item Item[T]) error {
+		if len(items) == limit {
+			cursor = last
+			return errStop
+		}
+		last = key
+		items = append(items, item)
+		return nil
+	})
+	if err == errStop {
+		err = nil
+	}
+	return
+}
+
+func (txn *Txn[T]) eachIndex(base []byte, options ScanOptions, fn func(key []byte, item Item[T]) error) (err error) {
+	return txn.each(base, options, func(key []byte, indexItem *badger.Item) (err error) {
+		var primaryKey []byte
+		if primaryKey, err = indexItem.ValueCopy(nil); err != nil {
+			return
+		}
+		var item *badger.Item
+		if item, err = txn.txn.Get(txn.collection.dataKey(primaryKey)); err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return
+		}
+		value := Item[T]{
+			Key:       primaryKey,
+			ExpiresAt: item.ExpiresAt(),
+		}
+		if err = item.Value(func(val []byte) (err error) {
+			value.Value, err = txn.collection.decode(val)
+			return
+		}); err != nil {
+			return
+		}
+		return fn(key, value)
+	})
+}
+
+// 更新所有索引 old 为旧值 不存在时为 nil
+func (txn *Txn[T]) putIndexes(key []byte, old *T, value *T, ttl time.Duration) (err error) {
+	var expiresAt uint64
+	if ttl > 0 {
+		expiresAt = uint64(time.Now().Add(ttl).Unix())
+	}
+	for _, state := range txn.indexes {
+		index := state.index
+		var oldValues, newValues [][]byte
+		if old != nil {
+			oldValues = index.Keys(*old)
+		}
+		if value != nil {
+			newValues = index.Keys(*value)
+		}
+		if err = txn.putIndex(index, state.generation, key, oldValues, newValues, expiresAt); err != nil {
+			return
+		}
+		if state.building == nil {
+			continue
+		}
+		// 新版本 冲突时 不影响写入 重建失败
+		var unique *UniqueError
+		if err = txn.putIndex(index, state.building.generation, key, oldValues, newValues, expiresAt); errors.As(err, &unique) {
+			state.building.mux.Lock()
+			if state.building.err == nil {
+				state.building.err = err
+			}
+			state.building.mux.Unlock()
+			err = nil
+		} else if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (txn *Txn[T]) putIndex(index *Index[T], generation uint64, key []byte, oldValues [][]byte, newValues [][]byte, expiresAt uint64) (err error) {
+	for _, value := range oldValues {
+		if containsBytes(newValues, value) {
+			continue
+		}
+		if err = txn.txn.Delete(txn.collection.indexKey(index, generation, value, key)); err != nil {
+			return
+		}
+	}
+	for _, value := range newValues {
+		indexKey := txn.collection.indexKey(index, generation, value, key)
+		if index.Unique {
+			var item *badger.Item
+			if item, err = txn.txn.Get(indexKey); err == nil {
+				var exists []byte
+				if exists, err = item.ValueCopy(nil); err != nil {
+					return
+				}
+				if !bytes.Equal(exists, key) {
+					return &UniqueError{Index: index.Name, Value: value, Key: exists}
+				}
+			} else if err != badger.ErrKeyNotFound {
+				return
+			}
+		}
+		entry := badger.NewEntry(indexKey, key)
+		entry.ExpiresAt = expiresAt
+		if err = txn.txn.SetEntry(entry); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func containsBytes(values [][]byte, value []byte) bool {
+	for _, val := range values {
+		if bytes.Equal(val, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// 转义 0x00 保证排序 并用 0x00 0x01 结尾区分前缀
+func encodeIndexValue(value []byte, terminate bool) []byte {
+	b := make([]byte, 0, len(value)+2)
+	for _, c := range value {
+		if c == 0 {
+			b = append(b, 0, 0xff)
+		} else {
+			b = append(b, c)
+		}
+	}
+	if terminate {
+		b = append(b, 0, 1)
+	}
+	return b
+}
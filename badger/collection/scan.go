@@ -0,0 +1,152 @@
+package collection
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	// Start 包含 End 不包含 Cursor 为上一页返回的游标
+	ScanOptions struct {
+		Prefix  []byte
+		Start   []byte
+		End     []byte
+		Cursor  []byte
+		Limit   int
+		Reverse bool
+	}
+
+	Item[T any] struct {
+		Key       []byte
+		Value     T
+		ExpiresAt uint64
+	}
+)
+
+var errStop = errors.New("stop")
+
+// 读取一页 还有下一页时 cursor 不为空
+func (txn *Txn[T]) Scan(options ScanOptions) (items []Item[T], cursor []byte, err error) {
+	limit := options.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	err = txn.Each(options, func(item Item[T]) error {
+		if len(items) == limit {
+			cursor = items[len(items)-1].Key
+			return errStop
+		}
+		items = append(items, item)
+		return nil
+	})
+	return
+}
+
+func (txn *Txn[T]) Each(options ScanOptions, fn func(item Item[T]) error) (err error) {
+	err = txn.each(txn.collection.dataKey(nil), options, func(key []byte, item *badger.Item) (err error) {
+		value := Item[T]{
+			Key:       key,
+			ExpiresAt: item.ExpiresAt(),
+		}
+		if err = item.Value(func(val []byte) (err error) {
+			value.Value, err = txn.collection.decode(val)
+			return
+		}); err != nil {
+			return
+		}
+		return fn(value)
+	})
+	if err == errStop {
+		err = nil
+	}
+	return
+}
+
+// 遍历 base 前缀下的 key 回调的 key 已去掉 base 前缀
+func (txn *Txn[T]) each(base []byte, options ScanOptions, fn func(key []byte, item *badger.Item) error) (err error) {
+	prefix := join(base, options.Prefix)
+	var lower, upper []byte
+	if options.Start != nil {
+		lower = join(base, options.Start)
+	}
+	if options.End != nil {
+		upper = join(base, options.End)
+	}
+
+	iteratorOptions := badger.DefaultIteratorOptions
+	iteratorOptions.Reverse = options.Reverse
+	iteratorOptions.Prefix = prefix
+	iterator := txn.txn.NewIterator(iteratorOptions)
+	defer iterator.Close()
+
+	var seek []byte
+	switch {
+	case options.Cursor != nil && !options.Reverse:
+		seek = append(join(base, options.Cursor), 0)
+	case options.Cursor != nil:
+		seek = join(base, options.Cursor)
+	case !options.Reverse:
+		seek = prefix
+		if lower != nil && bytes.Compare(lower, seek) > 0 {
+			seek = lower
+		}
+	default:
+		// 反向 seek 返回 小于等于 seek 的最大 key 前缀的后继 大于 前缀下的 所有 key
+		seek = successor(prefix)
+		if upper != nil && bytes.Compare(upper, seek) < 0 {
+			seek = upper
+		}
+	}
+
+	iterator.Seek(seek)
+	// 反向时 可能停在 前缀之外 的 后继 上
+	for options.Reverse && iterator.Item() != nil && !iterator.ValidForPrefix(prefix) && bytes.Compare(iterator.Item().Key(), prefix) > 0 {
+		iterator.Next()
+	}
+	for ; iterator.ValidForPrefix(prefix); iterator.Next() {
+		item := iterator.Item()
+		key := item.Key()
+		if options.Reverse {
+			if upper != nil && bytes.Compare(key, upper) >= 0 {
+				continue
+			}
+			if options.Cursor != nil && bytes.Compare(key, seek) >= 0 {
+				continue
+			}
+			if lower != nil && bytes.Compare(key, lower) < 0 {
+				return
+			}
+		} else {
+			if upper != nil && bytes.Compare(key, upper) >= 0 {
+				return
+			}
+			if lower != nil && bytes.Compare(key, lower) < 0 {
+				continue
+			}
+		}
+		if err = fn(item.KeyCopy(nil)[len(base):], item); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 最后一个 不是 0xff 的字节 加一 并截断
+func successor(prefix []byte) []byte {
+	b := join(prefix, nil)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] != 0xff {
+			b[i]++
+			return b[:i+1]
+		}
+	}
+	return nil
+}
+
+func join(a []byte, b []byte) []byte {
+	c := make([]byte, 0, len(a)+len(b))
+	c = append(c, a...)
+	return append(c, b...)
+}
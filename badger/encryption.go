@@ -0,0 +1,151 @@
+package libbadger
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/spf13/viper"
+)
+
+const (
+	KeyFormatHex    = "hex"
+	KeyFormatBase64 = "base64"
+	KeyFormatRaw    = "raw"
+)
+
+var ErrInvalidEncryptionKey = errors.New("badger encryption key must be 16, 24 or 32 bytes")
+var ErrInvalidKeyFormat = errors.New("badger encryption key format must be hex, base64 or raw")
+
+func ViperEncryption() (out OutOption) {
+	out.Option = viperEncryption(viperPrefix(""))
+	return
+}
+
+func viperEncryption(prefix string) Option {
+	return func(options badger.Options) (badger.Options, error) {
+		if indexCacheSize := viper.GetInt64(prefix + ".indexCacheSize"); indexCacheSize > 0 {
+			options = options.WithIndexCacheSize(indexCacheSize)
+		}
+
+		key, err := ReadEncryptionKey(
+			viper.GetString(prefix+".encryption.keyFormat"),
+			viper.GetString(prefix+".encryption.key"),
+			viper.GetString(prefix+".encryption.keyFile"),
+			viper.GetString(prefix+".encryption.keyEnv"),
+		)
+		if err != nil || len(key) == 0 {
+			return options, err
+		}
+		options = options.WithEncryptionKey(key)
+		if d := viper.GetDuration(prefix + ".encryption.keyRotationDuration"); d > 0 {
+			options = options.WithEncryptionKeyRotationDuration(d)
+		}
+
+		// 加密必须开启 index cache
+		if options.IndexCacheSize == 0 {
+			options = options.WithIndexCacheSize(1024 * 1024 * 64)
+		}
+		return options, nil
+	}
+}
+
+// 读取密钥 优先级 inline > file > env 都为空时返回空 format 为 hex base64 raw
+func ReadEncryptionKey(format string, inline string, file string, env string) (key []byte, err error) {
+	var b []byte
+	switch {
+	case inline != "":
+		b = []byte(inline)
+	case file != "":
+		if b, err = ioutil.ReadFile(file); err != nil {
+			return
+		}
+	case env != "":
+		if b = []byte(os.Getenv(env)); len(b) == 0 {
+			err = fmt.Errorf("badger encryption key env %s is empty", env)
+			return
+		}
+	default:
+		return
+	}
+	return decodeEncryptionKey(format, b)
+}
+
+// 格式 由配置指定 不猜测 raw 不去除空白
+func decodeEncryptionKey(format string, b []byte) (key []byte, err error) {
+	switch format {
+	case KeyFormatHex:
+		if key, err = hex.DecodeString(string(bytes.TrimSpace(b))); err != nil {
+			return nil, fmt.Errorf("badger encryption key hex: %w", err)
+		}
+	case KeyFormatBase64:
+		if key, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b))); err != nil {
+			return nil, fmt.Errorf("badger encryption key base64: %w", err)
+		}
+	case KeyFormatRaw:
+		key = b
+	default:
+		return nil, ErrInvalidKeyFormat
+	}
+	if !validEncryptionKey(key) {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return
+}
+
+func validEncryptionKey(key []byte) bool {
+	switch len(key) {
+	case 16, 24, 32:
+		return true
+	}
+	return false
+}
+
+// 离线更换密钥 dir 为 badger.Options.Dir 数据库必须已关闭 空密钥表示不加密
+func RotateEncryptionKey(dir string, oldKey []byte, newKey []byte) (err error) {
+	if len(newKey) != 0 && !validEncryptionKey(newKey) {
+		return ErrInvalidEncryptionKey
+	}
+	options := badger.KeyRegistryOptions{
+		Dir:                           dir,
+		ReadOnly:                      true,
+		EncryptionKey:                 oldKey,
+		EncryptionKeyRotationDuration: time.Hour * 24 * 10,
+	}
+	var keyRegistry *badger.KeyRegistry
+	if keyRegistry, err = badger.OpenKeyRegistry(options); err != nil {
+		return
+	}
+	defer keyRegistry.Close()
+
+	options.EncryptionKey = newKey
+	return badger.WriteKeyRegistry(keyRegistry, options)
+}
+
+// 命令行使用 从 viper 读取旧密钥 name 为空时 为默认实例 否则为 NewNamed 的命名实例
+// newKeyFile 为新密钥文件 格式与旧密钥相同 为空时解除加密
+func RotateEncryptionKeyViper(name string, dir string, newKeyFile string) (err error) {
+	var oldKey, newKey []byte
+	prefix := viperPrefix(name)
+	format := viper.GetString(prefix + ".encryption.keyFormat")
+	if oldKey, err = ReadEncryptionKey(
+		format,
+		viper.GetString(prefix+".encryption.key"),
+		viper.GetString(prefix+".encryption.keyFile"),
+		viper.GetString(prefix+".encryption.keyEnv"),
+	); err != nil {
+		return
+	}
+	if newKeyFile != "" {
+		if newKey, err = ReadEncryptionKey(format, "", newKeyFile, ""); err != nil {
+			return
+		}
+	}
+	return RotateEncryptionKey(dir, oldKey, newKey)
+}
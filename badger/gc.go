@@ -2,37 +2,402 @@ package libbadger
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	libhttp "github.com/otamoe/go-library/http"
+	"go.uber.org/fx"
 )
 
+type (
+	GCController struct {
+		db              *badger.DB
+		extendedOptions *ExtendedOptions
+		trigger         chan chan error
+		looping         int32
+
+		// 同一时间 只执行一个 RunValueLogGC
+		runMux sync.Mutex
+
+		mux   sync.Mutex
+		stats GCStats
+
+		// 自适应 状态
+		lastSize   int64
+		lastSample time.Time
+		budgetFrom time.Time
+	}
+
+	GCStats struct {
+		Adaptive      bool          `json:"adaptive"`
+		Runs          uint64        `json:"runs"`
+		Rewrites      uint64        `json:"rewrites"`
+		NoRewrites    uint64        `json:"noRewrites"`
+		Rejected      uint64        `json:"rejected"`
+		Errors        uint64        `json:"errors"`
+		Skipped       uint64        `json:"skipped"`
+		LastRun       time.Time     `json:"lastRun"`
+		LastResult    string        `json:"lastResult"`
+		Interval      time.Duration `json:"interval"`
+		WriteRate     float64       `json:"writeRate"`
+		VlogSize      int64         `json:"vlogSize"`
+		BudgetUsed    int64         `json:"budgetUsed"`
+		BudgetPerHour int64         `json:"budgetPerHour"`
+	}
+)
+
+func WithAdaptiveGC(maxBytesPerHour int64, writeRateLimit int64) func() (out OutExtendedOption) {
+	return func() (out OutExtendedOption) {
+		out.Option = AdaptiveGCOption(maxBytesPerHour, writeRateLimit)
+		return
+	}
+}
+
+// maxBytesPerHour 每小时最多重写的字节 writeRateLimit 每秒写入超过时暂停 0 不限制
+func AdaptiveGCOption(maxBytesPerHour int64, writeRateLimit int64) ExtendedOption {
+	return func(extendedOptions *ExtendedOptions) (err error) {
+		extendedOptions.GCAdaptive = true
+		extendedOptions.GCMaxBytesPerHour = maxBytesPerHour
+		extendedOptions.GCWriteRateLimit = writeRateLimit
+		return
+	}
+}
+
+// NewBadger 创建的 db 的 GC 循环
+var gcControllers sync.Map
+
+// 返回 NewBadger 已启动的 GC 其他方式打开的 db 新建并启动
+func NewGCController(lc fx.Lifecycle, extendedOptions *ExtendedOptions, db *badger.DB) (gc *GCController) {
+	if v, ok := gcControllers.Load(db); ok {
+		return v.(*GCController)
+	}
+	gc = newGCController(extendedOptions, db)
+	gc.lifecycle(lc)
+	return
+}
+
+func (gc *GCController) lifecycle(lc fx.Lifecycle) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			if gc.extendedOptions.GCDiscardRatio != 0 && gc.extendedOptions.GCInterval != 0 {
+				go gc.run(ctx)
+			}
+			return nil
+		},
+		OnStop: func(_ context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+}
+
 func GC(ctx context.Context, extendedOptions *ExtendedOptions, db *badger.DB) {
-	t := time.NewTicker(extendedOptions.GCInterval)
-	defer t.Stop()
+	newGCController(extendedOptions, db).run(ctx)
+}
+
+func newGCController(extendedOptions *ExtendedOptions, db *badger.DB) *GCController {
+	return &GCController{
+		db:              db,
+		extendedOptions: extendedOptions,
+		trigger:         make(chan chan error),
+		stats: GCStats{
+			Adaptive:      extendedOptions.GCAdaptive,
+			Interval:      extendedOptions.GCInterval,
+			BudgetPerHour: extendedOptions.GCMaxBytesPerHour,
+		},
+	}
+}
+
+// 手动执行一次 GC 等待 GC 循环 当前的执行结束 没有运行 GC 循环时 直接执行
+func (gc *GCController) Trigger(ctx context.Context) (err error) {
+	if atomic.LoadInt32(&gc.looping) == 0 {
+		return gc.runOnce()
+	}
+	errc := make(chan error, 1)
+	select {
+	case gc.trigger <- errc:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+func (gc *GCController) Stats() GCStats {
+	gc.mux.Lock()
+	defer gc.mux.Unlock()
+	return gc.stats
+}
+
+// GET 读取状态 POST 手动执行
+func (gc *GCController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var err error
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		err = gc.Trigger(r.Context())
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{
+		"stats": gc.Stats(),
+	}
+	if err != nil {
+		res["error"] = err.Error()
+	}
+	b, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+func (gc *GCController) Handler(path string) libhttp.HandlerFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == path {
+				gc.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// 注册到 libhttp
+func WithGCHandler(hosts []string, index int, path string) func(gc *GCController) (out libhttp.OutOption) {
+	return func(gc *GCController) (out libhttp.OutOption) {
+		return libhttp.WithHandler(hosts, index, gc.Handler(path))()
+	}
+}
+
+func (gc *GCController) run(ctx context.Context) {
+	atomic.StoreInt32(&gc.looping, 1)
+	defer atomic.StoreInt32(&gc.looping, 0)
+	extendedOptions := gc.extendedOptions
+	interval := extendedOptions.GCInterval
+	if extendedOptions.GCAdaptive {
+		interval = gc.minInterval()
+	}
+	t := time.NewTimer(interval)
+	defer t.Stop()
 	for {
+		var errc chan error
 		select {
 		case <-t.C:
-			switch db.RunValueLogGC(extendedOptions.GCDiscardRatio); err {
-			case badger.ErrNoRewrite, badger.ErrRejected:
-				// 没写入 被拒绝
-				t.Reset(extendedOptions.GCInterval)
+		case errc = <-gc.trigger:
+			if !t.Stop() {
+				<-t.C
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		var err error
+		if extendedOptions.GCAdaptive && errc == nil {
+			interval, err = gc.adaptive(interval)
+		} else {
+			err = gc.runOnce()
+			switch err {
 			case nil:
 				// 无错误
-				t.Reset(extendedOptions.GCSleep)
-			case badger.ErrDBClosed:
-				// 被关闭 返回
-
-				return
+				interval = extendedOptions.GCSleep
 			default:
-				// 其他错误
-				db.Opts().Logger.Errorf("error during a GC cycle %s", err)
-				// Not much we can do on a random error but log it and continue.
-				t.Reset(extendedOptions.GCInterval)
+				// 没写入 被拒绝 其他错误
+				interval = extendedOptions.GCInterval
 			}
-		case <-ctx.Done():
+		}
+		if errc != nil {
+			errc <- err
+		}
+		if err == badger.ErrDBClosed {
+			// 被关闭 返回
 			return
 		}
+
+		gc.mux.Lock()
+		gc.stats.Interval = interval
+		gc.mux.Unlock()
+		t.Reset(interval)
+	}
+}
+
+func (gc *GCController) runOnce() (err error) {
+	gc.runMux.Lock()
+	defer gc.runMux.Unlock()
+	rewrite := gc.rewriteEstimate()
+	err = gc.db.RunValueLogGC(gc.extendedOptions.GCDiscardRatio)
+	gcMetrics(gc.extendedOptions, err)
+
+	gc.mux.Lock()
+	defer gc.mux.Unlock()
+	gc.stats.Runs++
+	gc.stats.LastRun = time.Now()
+	switch err {
+	case nil:
+		gc.stats.Rewrites++
+		gc.stats.LastResult = "rewrite"
+		gc.stats.BudgetUsed += rewrite
+	case badger.ErrNoRewrite:
+		gc.stats.NoRewrites++
+		gc.stats.LastResult = "no_rewrite"
+	case badger.ErrRejected:
+		gc.stats.Rejected++
+		gc.stats.LastResult = "rejected"
+	case badger.ErrDBClosed:
+		gc.stats.LastResult = "closed"
+	default:
+		gc.stats.Errors++
+		gc.stats.LastResult = "error"
+		gc.db.Opts().Logger.Errorf("error during a GC cycle %s", err)
+	}
+	return
+}
+
+// 自适应 写入过快时退避 有可回收空间时 连续执行 超出每小时预算时等待
+func (gc *GCController) adaptive(interval time.Duration) (next time.Duration, err error) {
+	extendedOptions := gc.extendedOptions
+	now := time.Now()
+	lsm, vlog := gc.db.Size()
+	size := lsm + vlog
+
+	gc.mux.Lock()
+	var writeRate float64
+	var growth int64
+	if !gc.lastSample.IsZero() {
+		if elapsed := now.Sub(gc.lastSample).Seconds(); elapsed > 0 && size > gc.lastSize {
+			writeRate = float64(size-gc.lastSize) / elapsed
+		}
+		growth = vlog - gc.stats.VlogSize
+	}
+	gc.lastSample = now
+	gc.lastSize = size
+	gc.stats.WriteRate = writeRate
+	gc.stats.VlogSize = vlog
+
+	// 每小时 预算
+	if gc.budgetFrom.IsZero() || now.Sub(gc.budgetFrom) >= time.Hour {
+		gc.budgetFrom = now
+		gc.stats.BudgetUsed = 0
+	}
+	budgetExceeded := extendedOptions.GCMaxBytesPerHour > 0 && gc.stats.BudgetUsed >= extendedOptions.GCMaxBytesPerHour
+	budgetReset := gc.budgetFrom.Add(time.Hour).Sub(now)
+	gc.mux.Unlock()
+
+	// 超出预算
+	if budgetExceeded {
+		gc.skip()
+		return budgetReset, nil
+	}
+
+	// 写入高峰 退避
+	if extendedOptions.GCWriteRateLimit > 0 && writeRate > float64(extendedOptions.GCWriteRateLimit) {
+		gc.skip()
+		return gc.backoff(interval), nil
+	}
+
+	switch err = gc.runOnce(); err {
+	case nil:
+		// 还有可回收空间 尽快再次执行
+		next = gc.minInterval()
+	case badger.ErrRejected:
+		next = interval
+	default:
+		next = gc.backoff(interval)
+	}
+
+	// value log 增长超过一个文件 提前检查
+	if growth >= gc.db.Opts().ValueLogFileSize && next > gc.minInterval()*4 {
+		next = gc.minInterval() * 4
+	}
+	return
+}
+
+// RunValueLogGC 重写 discard 最多的文件 重写的字节 为 文件大小 减去 discard
+// badger 不导出 discard 统计 从 ValueDir/DISCARD 读取 每条 16 字节 fid discard 大端 fid 为 0 结束
+// 读取失败 (例如 内存模式) 时 按一个 ValueLogFileSize 计算
+func (gc *GCController) rewriteEstimate() int64 {
+	options := gc.db.Opts()
+	b, err := os.ReadFile(filepath.Join(options.ValueDir, "DISCARD"))
+	if err != nil {
+		return options.ValueLogFileSize
+	}
+	var maxFid, maxDiscard uint64
+	for i := 0; i+16 <= len(b); i += 16 {
+		fid := binary.BigEndian.Uint64(b[i:])
+		if fid == 0 {
+			break
+		}
+		if discard := binary.BigEndian.Uint64(b[i+8:]); discard > maxDiscard {
+			maxFid, maxDiscard = fid, discard
+		}
+	}
+	if maxFid == 0 {
+		return options.ValueLogFileSize
+	}
+	fi, err := os.Stat(filepath.Join(options.ValueDir, fmt.Sprintf("%06d.vlog", maxFid)))
+	if err != nil {
+		return options.ValueLogFileSize
+	}
+	if live := fi.Size() - int64(maxDiscard); live > 0 {
+		return live
+	}
+	return 0
+}
+
+func (gc *GCController) skip() {
+	gc.mux.Lock()
+	defer gc.mux.Unlock()
+	gc.stats.Skipped++
+}
+
+func (gc *GCController) minInterval() time.Duration {
+	if gc.extendedOptions.GCSleep > 0 {
+		return gc.extendedOptions.GCSleep
+	}
+	return time.Second * 15
+}
+
+func (gc *GCController) backoff(interval time.Duration) time.Duration {
+	interval *= 2
+	if interval < gc.minInterval() {
+		interval = gc.minInterval()
+	}
+	if interval > gc.extendedOptions.GCInterval {
+		interval = gc.extendedOptions.GCInterval
+	}
+	return interval
+}
+
+func gcMetrics(extendedOptions *ExtendedOptions, err error) {
+	if extendedOptions.Metrics == nil {
+		return
+	}
+	var result string
+	switch err {
+	case nil:
+		result = "rewrite"
+	case badger.ErrNoRewrite:
+		result = "no_rewrite"
+	case badger.ErrRejected:
+		result = "rejected"
+	case badger.ErrDBClosed:
+		return
+	default:
+		result = "error"
 	}
+	extendedOptions.Metrics.Counter("badger_gc_total", 1, "name", metricsName(extendedOptions), "result", result)
 }
@@ -0,0 +1,32 @@
+package libbadger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dgraph-io/badger/v3"
+	libhealth "github.com/otamoe/go-library/health"
+)
+
+var ErrClosed = errors.New("badger is closed")
+
+func HealthChecker(db *badger.DB) (out libhealth.OutChecker) {
+	out.Checker = healthChecker("badger", db)
+	return
+}
+
+// 本地数据库 关闭后 无法恢复 同时影响存活检查
+func healthChecker(name string, db *badger.DB) libhealth.Checker {
+	return libhealth.Checker{
+		Name:     name,
+		Liveness: true,
+		Check: func(ctx context.Context) (err error) {
+			if db.IsClosed() {
+				return ErrClosed
+			}
+			return db.View(func(txn *badger.Txn) error {
+				return nil
+			})
+		},
+	}
+}
@@ -19,8 +19,12 @@ func (logger *compatLogger) Warningf(format string, args ...interface{}) {
 }
 
 func Logger() (out OutOption) {
-	out.Option = func(o badger.Options) (badger.Options, error) {
-		return o.WithLogger(&compatLogger{liblogger.Get("badger").Sugar()}), nil
-	}
+	out.Option = logger("")
 	return
 }
+
+func logger(name string) Option {
+	return func(o badger.Options) (badger.Options, error) {
+		return o.WithLogger(&compatLogger{liblogger.Get(fullName(name)).Sugar()}), nil
+	}
+}
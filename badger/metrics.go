@@ -0,0 +1,77 @@
+package libbadger
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	libmetrics "github.com/otamoe/go-library/metrics"
+)
+
+func ExtendedMetrics(metrics libmetrics.Metrics) (out OutExtendedOption) {
+	out.Option = MetricsOption(metrics)
+	return
+}
+
+func MetricsOption(metrics libmetrics.Metrics) ExtendedOption {
+	return func(extendedOptions *ExtendedOptions) (err error) {
+		extendedOptions.Metrics = metrics
+		if registry, ok := metrics.(*libmetrics.Registry); ok {
+			registry.Help("badger_gc_total", "Badger value log GC cycles by result")
+			registry.Help("badger_lsm_size_bytes", "Badger LSM tree size")
+			registry.Help("badger_vlog_size_bytes", "Badger value log size")
+			registry.Help("badger_block_cache_hit_ratio", "Badger block cache hit ratio")
+			registry.Help("badger_index_cache_hit_ratio", "Badger index cache hit ratio")
+			registry.Help("badger_pending_writes", "Badger pending write requests")
+		}
+		return
+	}
+}
+
+func Metrics(ctx context.Context, extendedOptions *ExtendedOptions, db *badger.DB) {
+	t := time.NewTicker(extendedOptions.MetricsInterval)
+	defer t.Stop()
+	for {
+		collectMetrics(extendedOptions, db)
+		select {
+		case <-t.C:
+			if db.IsClosed() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func collectMetrics(extendedOptions *ExtendedOptions, db *badger.DB) {
+	metrics := extendedOptions.Metrics
+	name := metricsName(extendedOptions)
+	lsm, vlog := db.Size()
+	metrics.Gauge("badger_lsm_size_bytes", float64(lsm), "name", name)
+	metrics.Gauge("badger_vlog_size_bytes", float64(vlog), "name", name)
+	if m := db.BlockCacheMetrics(); m != nil {
+		metrics.Gauge("badger_block_cache_hit_ratio", m.Ratio(), "name", name)
+	}
+	if m := db.IndexCacheMetrics(); m != nil {
+		metrics.Gauge("badger_index_cache_hit_ratio", m.Ratio(), "name", name)
+	}
+
+	// badger 只通过 expvar 暴露
+	if pendingWrites, ok := expvar.Get("badger_v3_pending_writes_total").(*expvar.Map); ok {
+		if v := pendingWrites.Get(db.Opts().Dir); v != nil {
+			if f, err := strconv.ParseFloat(v.String(), 64); err == nil {
+				metrics.Gauge("badger_pending_writes", f, "name", name)
+			}
+		}
+	}
+}
+
+func metricsName(extendedOptions *ExtendedOptions) string {
+	if extendedOptions.Name == "" {
+		return "default"
+	}
+	return extendedOptions.Name
+}
@@ -0,0 +1,108 @@
+package libbadger
+
+import (
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	libhealth "github.com/otamoe/go-library/health"
+	libviper "github.com/otamoe/go-library/viper"
+	"go.uber.org/fx"
+)
+
+var namedDefaults sync.Map
+
+// 注册 命名实例 的 viper 默认值 和 命令行参数 badgers.{name}.*
+// 需要在 init 中 libviper.Parse 之前调用 否则 只有 配置文件 和 环境变量 生效
+func RegisterNamed(name string) {
+	if _, loaded := namedDefaults.LoadOrStore(name, true); !loaded {
+		setDefaults(viperPrefix(name), libviper.SetDefault)
+	}
+}
+
+// 命名实例 通过 `name:"badger.{name}"` 获取 *badger.DB
+// 选项 group 为 badgerOptions.{name} 和 badgerExtendedOptions.{name}
+// viper 前缀 为 badgers.{name} logger 名 为 badger.{name}
+// 未调用 RegisterNamed 时 在这里注册默认值 此时 命令行参数 不生效
+func NewNamed(name string) fx.Option {
+	RegisterNamed(name)
+
+	resultName := NamedTag(name)
+	return fx.Options(
+		fx.Provide(fx.Annotate(
+			func(options []Option) (badger.Options, error) {
+				return newOptions(name, options)
+			},
+			fx.ParamTags(namedGroupTag("badgerOptions", name)),
+			fx.ResultTags(resultName),
+		)),
+		fx.Provide(fx.Annotate(
+			func(options []ExtendedOption) (*ExtendedOptions, error) {
+				return newExtendedOptions(name, options)
+			},
+			fx.ParamTags(namedGroupTag("badgerExtendedOptions", name)),
+			fx.ResultTags(resultName),
+		)),
+
+		NamedOption(name, viperLoggerLevel(name)),
+		NamedOption(name, viperEncryption(viperPrefix(name))),
+		NamedOption(name, logger(name)),
+
+		fx.Provide(fx.Annotate(
+			NewBadger,
+			fx.ParamTags(``, resultName, resultName),
+			fx.ResultTags(resultName),
+		)),
+		fx.Provide(fx.Annotate(
+			NewGCController,
+			fx.ParamTags(``, resultName, resultName),
+			fx.ResultTags(resultName),
+		)),
+		fx.Invoke(fx.Annotate(
+			func(*GCController) {},
+			fx.ParamTags(resultName),
+		)),
+		fx.Provide(fx.Annotate(
+			func(db *badger.DB) libhealth.Checker {
+				return healthChecker(fullName(name), db)
+			},
+			fx.ParamTags(resultName),
+			fx.ResultTags(`group:"healthCheckers"`),
+		)),
+	)
+}
+
+// 命名实例 的 badger 选项
+func NamedOption(name string, option Option) fx.Option {
+	return fx.Provide(fx.Annotate(
+		func() Option {
+			return option
+		},
+		fx.ResultTags(namedGroupTag("badgerOptions", name)),
+	))
+}
+
+// 命名实例 的 扩展选项
+func NamedExtendedOption(name string, option ExtendedOption) fx.Option {
+	return fx.Provide(fx.Annotate(
+		func() ExtendedOption {
+			return option
+		},
+		fx.ResultTags(namedGroupTag("badgerExtendedOptions", name)),
+	))
+}
+
+func NamedExtendedMetrics(name string) fx.Option {
+	return fx.Provide(fx.Annotate(
+		MetricsOption,
+		fx.ParamTags(``),
+		fx.ResultTags(namedGroupTag("badgerExtendedOptions", name)),
+	))
+}
+
+func NamedTag(name string) string {
+	return `name:"` + fullName(name) + `"`
+}
+
+func namedGroupTag(group string, name string) string {
+	return `group:"` + group + `.` + name + `"`
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	libmetrics "github.com/otamoe/go-library/metrics"
 	"github.com/shirou/gopsutil/v3/mem"
 	"go.uber.org/fx"
 )
@@ -26,9 +27,24 @@ type (
 	Option func(badger.Options) (badger.Options, error)
 
 	ExtendedOptions struct {
+		Name string
+
 		GCDiscardRatio float64
 		GCInterval     time.Duration
 		GCSleep        time.Duration
+
+		// 自适应 GC
+		GCAdaptive        bool
+		GCMaxBytesPerHour int64
+		GCWriteRateLimit  int64
+
+		BackupDir      string
+		BackupInterval time.Duration
+		BackupFull     int
+		BackupKeep     int
+
+		Metrics         libmetrics.Metrics
+		MetricsInterval time.Duration
 	}
 
 	InExtendedOptions struct {
@@ -45,8 +61,12 @@ type (
 )
 
 func NewOptions(inOptions InOptions) (options badger.Options, err error) {
-	options = DefaultOptions()
-	for _, o := range inOptions.Options {
+	return newOptions("", inOptions.Options)
+}
+
+func newOptions(name string, opts []Option) (options badger.Options, err error) {
+	options = defaultOptions(name)
+	for _, o := range opts {
 		if options, err = o(options); err != nil {
 			return
 		}
@@ -55,13 +75,25 @@ func NewOptions(inOptions InOptions) (options badger.Options, err error) {
 }
 
 func NewExtendedOption(inExtendedOptions InExtendedOptions) (extendedOptions *ExtendedOptions, err error) {
+	return newExtendedOptions("", inExtendedOptions.Options)
+}
+
+func newExtendedOptions(name string, opts []ExtendedOption) (extendedOptions *ExtendedOptions, err error) {
 	extendedOptions = &ExtendedOptions{
+		Name: name,
+
 		GCDiscardRatio: 0.5,
 		GCInterval:     time.Minute * 15,
 		GCSleep:        time.Second * 15,
+
+		BackupInterval: time.Hour,
+		BackupFull:     24,
+		BackupKeep:     3,
+
+		MetricsInterval: time.Second * 15,
 	}
 	// 扩展选项
-	for _, o := range inExtendedOptions.Options {
+	for _, o := range opts {
 		if err = o(extendedOptions); err != nil {
 			return
 		}
@@ -88,24 +120,39 @@ func NewBadger(lc fx.Lifecycle, options badger.Options, extendedOptions *Extende
 	ctx, cancel := context.WithCancel(context.Background())
 	lc.Append(fx.Hook{
 		OnStart: func(_ context.Context) error {
-			if extendedOptions.GCDiscardRatio != 0 && extendedOptions.GCInterval != 0 {
-				go GC(ctx, extendedOptions, db)
+			if extendedOptions.BackupDir != "" && extendedOptions.BackupInterval != 0 {
+				go Backup(ctx, extendedOptions, db)
+			}
+			if extendedOptions.Metrics != nil && extendedOptions.MetricsInterval != 0 {
+				go Metrics(ctx, extendedOptions, db)
 			}
 			return nil
 		},
 		OnStop: func(c context.Context) error {
 			cancel()
+			gcControllers.Delete(db)
 			return db.Close()
 		},
 	})
+
+	// 默认 启动 GC 在关闭 db 之前停止 NewGCController 返回同一个
+	gc := newGCController(extendedOptions, db)
+	gcControllers.Store(db, gc)
+	gc.lifecycle(lc)
 	return
 }
 
 func DefaultOptions() badger.Options {
+	return defaultOptions("")
+}
+
+// 命名实例 使用 ~/.badger/{name} 目录
+func defaultOptions(name string) badger.Options {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
 	}
+	baseDir := path.Join(homeDir, ".badger", name)
 	memorySize := GetMemorySize()
 	memTableSize := memorySize / 32
 	if memTableSize > 1024*1024*256 {
@@ -119,8 +166,8 @@ func DefaultOptions() badger.Options {
 	if indexCacheSize > 1024*1024*1024 {
 		indexCacheSize = 1024 * 1024 * 1024
 	}
-	return badger.DefaultOptions(path.Join(homeDir, ".badger/index")).
-		WithValueDir(path.Join(homeDir, ".badger/value")).
+	return badger.DefaultOptions(path.Join(baseDir, "index")).
+		WithValueDir(path.Join(baseDir, "value")).
 		WithBaseTableSize(1024 * 1024 * 4).
 		WithMemTableSize(memTableSize).
 		WithValueThreshold(1024 * 1).
@@ -132,7 +179,7 @@ func GetMemorySize() int64 {
 	// 读取内存
 	memStat, err := mem.VirtualMemory()
 	if err != nil {
-		panic(fmt.Errorf("get memory size", err))
+		panic(fmt.Errorf("get memory size: %w", err))
 	}
 	return int64(memStat.Total)
 }
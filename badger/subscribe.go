@@ -0,0 +1,509 @@
+package libbadger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"go.uber.org/fx"
+)
+
+type (
+	Event struct {
+		Key       []byte
+		Value     []byte
+		UserMeta  byte
+		Version   uint64
+		ExpiresAt uint64
+		Deleted   bool
+
+		// 空值 需要读取 是否是删除标记 在回调之外 读取
+		checkDeleted bool
+	}
+
+	// Name 用于持久化游标 重启后从游标继续
+	// Handler 为空时 事件写入 C
+	// Snapshot 没有游标时 先推送已有数据
+	Subscription struct {
+		Name          string
+		Prefixes      [][]byte
+		BatchSize     int
+		BatchInterval time.Duration
+		Buffer        int
+		Snapshot      bool
+		Handler       func(ctx context.Context, events []Event) error
+		C             chan []Event
+	}
+
+	InSubscriptions struct {
+		fx.In
+		Subscriptions []*Subscription `group:"badgerSubscriptions"`
+	}
+
+	OutSubscription struct {
+		fx.Out
+		Subscription *Subscription `group:"badgerSubscriptions"`
+	}
+
+	Feed struct {
+		db            *badger.DB
+		subscriptions []*Subscription
+		cancel        context.CancelFunc
+		wg            sync.WaitGroup
+	}
+)
+
+// 游标 key 前缀 与 badger 的 !badger! 相同 为保留前缀 业务数据 不能使用
+// 订阅 和 补齐 都会跳过 这个前缀的 key
+var SubscriptionCursorPrefix = []byte("!cdc!")
+
+var ErrSubscriptionName = errors.New("badger subscription name is empty")
+
+// 自定义 provider 写入 badgerSubscriptions group 时 需要 fx.Invoke(func(*Feed) {})
+func Subscribe(subscription *Subscription) fx.Option {
+	return fx.Options(
+		fx.Provide(func() (out OutSubscription) {
+			out.Subscription = subscription
+			return
+		}),
+		fx.Invoke(func(*Feed) {}),
+	)
+}
+
+func NewFeed(lc fx.Lifecycle, db *badger.DB, inSubscriptions InSubscriptions) (feed *Feed, err error) {
+	feed = &Feed{
+		db: db,
+	}
+	for _, subscription := range inSubscriptions.Subscriptions {
+		if subscription.Name == "" {
+			err = ErrSubscriptionName
+			return
+		}
+		if subscription.BatchSize <= 0 {
+			subscription.BatchSize = 128
+		}
+		if subscription.BatchInterval <= 0 {
+			subscription.BatchInterval = time.Millisecond * 100
+		}
+		if subscription.Buffer <= 0 {
+			subscription.Buffer = 16
+		}
+		if subscription.Handler == nil && subscription.C == nil {
+			subscription.C = make(chan []Event, subscription.Buffer)
+		}
+		feed.subscriptions = append(feed.subscriptions, subscription)
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			var ctx context.Context
+			ctx, feed.cancel = context.WithCancel(context.Background())
+			for _, subscription := range feed.subscriptions {
+				feed.wg.Add(1)
+				go func(subscription *Subscription) {
+					defer feed.wg.Done()
+					feed.run(ctx, subscription)
+				}(subscription)
+			}
+			return nil
+		},
+		OnStop: func(_ context.Context) error {
+			if feed.cancel != nil {
+				feed.cancel()
+			}
+			feed.wg.Wait()
+			return nil
+		},
+	})
+	return
+}
+
+// 读取游标 不存在时返回 0
+func (feed *Feed) Cursor(name string) (version uint64, err error) {
+	err = feed.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(cursorKey(name))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) == 8 {
+				version = binary.BigEndian.Uint64(val)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+func (feed *Feed) setCursor(name string, version uint64) (err error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, version)
+	return feed.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(cursorKey(name), b)
+	})
+}
+
+func (feed *Feed) run(ctx context.Context, subscription *Subscription) {
+	logger := feed.db.Opts().Logger
+	for {
+		err := feed.subscribe(ctx, subscription)
+		if err == nil || ctx.Err() != nil || feed.db.IsClosed() {
+			return
+		}
+		logger.Errorf("subscription %s %s", subscription.Name, err)
+		select {
+		case <-time.After(time.Second * 5):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (feed *Feed) subscribe(ctx context.Context, subscription *Subscription) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var cursor uint64
+	if cursor, err = feed.Cursor(subscription.Name); err != nil {
+		return
+	}
+
+	// badger 发布时 持有锁 所有写入 都要等待 回调不能阻塞
+	// live 满时 丢弃 并标记 overflow 由下面的循环 从游标 重新扫描
+	marker := cursorKey(subscription.Name)
+	ready := make(chan struct{})
+	var readyOnce sync.Once
+	var overflow int32
+	live := make(chan []Event, subscription.Buffer)
+	errc := make(chan error, 1)
+	matches := []pb.Match{{Prefix: marker}}
+	if len(subscription.Prefixes) == 0 {
+		matches = append(matches, pb.Match{Prefix: []byte{}})
+	}
+	for _, prefix := range subscription.Prefixes {
+		matches = append(matches, pb.Match{Prefix: prefix})
+	}
+	go func() {
+		errc <- feed.db.Subscribe(ctx, func(list *badger.KVList) error {
+			var events []Event
+			for _, kv := range list.Kv {
+				if bytes.HasPrefix(kv.Key, SubscriptionCursorPrefix) {
+					if bytes.Equal(kv.Key, marker) {
+						readyOnce.Do(func() {
+							close(ready)
+						})
+					}
+					continue
+				}
+				if !subscription.match(kv.Key) || atomic.LoadInt32(&overflow) == 1 {
+					continue
+				}
+				// 发布的 Meta 是 UserMeta 删除 和 空值 需要再读取一次
+				// 回调中 不能读取 db 读取要等待 提交 提交要等待 发布 发布要等待 回调
+				events = append(events, Event{
+					Key:          kv.Key,
+					Value:        kv.Value,
+					UserMeta:     userMeta(kv.Meta),
+					Version:      kv.Version,
+					ExpiresAt:    kv.ExpiresAt,
+					checkDeleted: len(kv.Value) == 0,
+				})
+			}
+			if len(events) == 0 {
+				return nil
+			}
+			select {
+			case live <- events:
+			default:
+				atomic.StoreInt32(&overflow, 1)
+			}
+			return nil
+		}, matches)
+	}()
+
+	// 写入游标 收到后 说明订阅已生效
+	if cursor == 0 && !subscription.Snapshot {
+		cursor = feed.db.MaxVersion()
+	}
+	for waiting := true; waiting; {
+		if err = feed.setCursor(subscription.Name, cursor); err != nil {
+			return
+		}
+		select {
+		case <-ready:
+			waiting = false
+		case <-time.After(time.Millisecond * 100):
+		case err = <-errc:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	// 游标 在单独的 goroutine 写入 只保留最新的 重启后 最多重复推送 未写入的部分
+	cursors := make(chan uint64, 1)
+	cursorDone := make(chan struct{})
+	go func() {
+		defer close(cursorDone)
+		for version := range cursors {
+			if err := feed.setCursor(subscription.Name, version); err != nil && !feed.db.IsClosed() {
+				feed.db.Opts().Logger.Warningf("subscription %s cursor %s", subscription.Name, err)
+			}
+		}
+	}()
+	defer func() {
+		close(cursors)
+		<-cursorDone
+	}()
+	saveCursor := func(version uint64) {
+		for {
+			select {
+			case cursors <- version:
+				return
+			default:
+			}
+			select {
+			case <-cursors:
+			default:
+			}
+		}
+	}
+
+	// 补齐 游标之后 的数据 live 中 版本不大于 scanned 的 已推送
+	var scanned uint64
+	resync := func() (err error) {
+		var readTs uint64
+		if readTs, err = feed.catchUp(ctx, subscription, cursor); err != nil {
+			return
+		}
+		if readTs > cursor {
+			cursor = readTs
+			saveCursor(cursor)
+		}
+		if readTs > scanned {
+			scanned = readTs
+		}
+		return
+	}
+	if err = resync(); err != nil {
+		return
+	}
+
+	// 实时数据
+	t := time.NewTicker(subscription.BatchInterval)
+	defer t.Stop()
+	var batch []Event
+	flush := func() (err error) {
+		if len(batch) == 0 {
+			return
+		}
+		if err = feed.deliver(ctx, subscription, batch); err != nil {
+			return
+		}
+		for _, event := range batch {
+			if event.Version > cursor {
+				cursor = event.Version
+			}
+		}
+		batch = nil
+		saveCursor(cursor)
+		return
+	}
+	// 有丢弃的事件 推送已收到的 之后 清空 live 从游标 重新扫描
+	checkOverflow := func() (err error) {
+		if atomic.LoadInt32(&overflow) == 0 {
+			return
+		}
+		if err = flush(); err != nil {
+			return
+		}
+		atomic.StoreInt32(&overflow, 0)
+		for drained := false; !drained; {
+			select {
+			case <-live:
+			default:
+				drained = true
+			}
+		}
+		return resync()
+	}
+	for {
+		select {
+		case events := <-live:
+			if err = checkOverflow(); err != nil {
+				return
+			}
+			for _, event := range events {
+				if event.Version <= scanned {
+					continue
+				}
+				if event.checkDeleted {
+					event.Deleted = feed.deleted(event.Key, event.Version)
+					event.checkDeleted = false
+				}
+				batch = append(batch, event)
+			}
+			if len(batch) >= subscription.BatchSize {
+				if err = flush(); err != nil {
+					return
+				}
+			}
+		case <-t.C:
+			if err = checkOverflow(); err != nil {
+				return
+			}
+			if err = flush(); err != nil {
+				return
+			}
+		case err = <-errc:
+			if e := flush(); e != nil && err == nil {
+				err = e
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 扫描版本大于 cursor 的数据 包括删除标记 返回读取时的版本
+func (feed *Feed) catchUp(ctx context.Context, subscription *Subscription, cursor uint64) (readTs uint64, err error) {
+	txn := feed.db.NewTransaction(false)
+	defer txn.Discard()
+	readTs = txn.ReadTs()
+
+	prefixes := subscription.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = [][]byte{{}}
+	}
+	var batch []Event
+	for _, prefix := range prefixes {
+		iteratorOptions := badger.DefaultIteratorOptions
+		iteratorOptions.AllVersions = true
+		iteratorOptions.Prefix = prefix
+		iteratorOptions.SinceTs = cursor
+		iterator := txn.NewIterator(iteratorOptions)
+		var last []byte
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			item := iterator.Item()
+			// 只要最新的版本
+			if last != nil && bytes.Equal(item.Key(), last) {
+				continue
+			}
+			last = item.KeyCopy(nil)
+			if item.Version() <= cursor || bytes.HasPrefix(last, SubscriptionCursorPrefix) {
+				continue
+			}
+			event := Event{
+				Key:       last,
+				UserMeta:  item.UserMeta(),
+				Version:   item.Version(),
+				ExpiresAt: item.ExpiresAt(),
+				Deleted:   item.IsDeletedOrExpired(),
+			}
+			if !event.Deleted {
+				if event.Value, err = item.ValueCopy(nil); err != nil {
+					iterator.Close()
+					return
+				}
+			}
+			batch = append(batch, event)
+			if len(batch) >= subscription.BatchSize {
+				if err = feed.deliver(ctx, subscription, batch); err != nil {
+					iterator.Close()
+					return
+				}
+				batch = nil
+			}
+		}
+		iterator.Close()
+	}
+	if len(batch) != 0 {
+		err = feed.deliver(ctx, subscription, batch)
+	}
+	return
+}
+
+// Handler 返回错误时 重试直到成功或退出
+func (feed *Feed) deliver(ctx context.Context, subscription *Subscription, events []Event) (err error) {
+	if subscription.Handler == nil {
+		select {
+		case subscription.C <- events:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	sleep := time.Millisecond * 100
+	for {
+		if err = subscription.Handler(ctx, events); err == nil {
+			return
+		}
+		feed.db.Opts().Logger.Warningf("subscription %s handler %s", subscription.Name, err)
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if sleep < time.Second*10 {
+			sleep *= 2
+		}
+	}
+}
+
+// 读取指定版本 是否是删除标记 版本已被压缩时 按当前是否存在判断
+func (feed *Feed) deleted(key []byte, version uint64) (deleted bool) {
+	deleted = true
+	feed.db.View(func(txn *badger.Txn) error {
+		iteratorOptions := badger.DefaultIteratorOptions
+		iteratorOptions.AllVersions = true
+		iteratorOptions.PrefetchValues = false
+		iteratorOptions.Prefix = key
+		iterator := txn.NewIterator(iteratorOptions)
+		defer iterator.Close()
+		for iterator.Seek(key); iterator.Valid(); iterator.Next() {
+			item := iterator.Item()
+			if !bytes.Equal(item.Key(), key) {
+				break
+			}
+			if item.Version() <= version {
+				deleted = item.IsDeletedOrExpired()
+				break
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (subscription *Subscription) match(key []byte) bool {
+	if len(subscription.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range subscription.Prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func cursorKey(name string) []byte {
+	return append(append([]byte{}, SubscriptionCursorPrefix...), name...)
+}
+
+func userMeta(b []byte) byte {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0]
+}
@@ -1,20 +1,56 @@
 package libbadger
 
 import (
+	"time"
+
 	"github.com/dgraph-io/badger/v3"
 	liblogger "github.com/otamoe/go-library/logger"
+	libviper "github.com/otamoe/go-library/viper"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+func init() {
+	setDefaults(viperPrefix(""), libviper.SetDefault)
+}
+
+func setDefaults(prefix string, setDefault func(name string, value interface{}, usage string)) {
+	setDefault(prefix+".indexCacheSize", int64(0), prefix+" index cache size 0 auto")
+	setDefault(prefix+".encryption.key", "", prefix+" encryption key")
+	setDefault(prefix+".encryption.keyFormat", KeyFormatHex, prefix+" encryption key format hex, base64 or raw")
+	setDefault(prefix+".encryption.keyFile", "", prefix+" encryption key file")
+	setDefault(prefix+".encryption.keyEnv", "", prefix+" encryption key environment variable name")
+	setDefault(prefix+".encryption.keyRotationDuration", time.Hour*24*10, prefix+" encryption key rotation duration")
+}
+
+// viper 前缀 默认实例为 badger 命名实例为 badgers.{name} 避免与 默认实例的 key 冲突
+func viperPrefix(name string) string {
+	if name == "" {
+		return "badger"
+	}
+	return "badgers." + name
+}
+
+// logger 名 和 fx 名 默认实例为 badger 命名实例为 badger.{name}
+func fullName(name string) string {
+	if name == "" {
+		return "badger"
+	}
+	return "badger." + name
+}
+
 func ViperLoggerLevel() (out OutOption) {
-	out.Option = func(out badger.Options) (badger.Options, error) {
+	out.Option = viperLoggerLevel("")
+	return
+}
+
+func viperLoggerLevel(name string) Option {
+	return func(out badger.Options) (badger.Options, error) {
 		if viper.GetString("env") == "development" {
-			liblogger.SetLevel("badger", zap.DebugLevel)
+			liblogger.SetLevel(fullName(name), zap.DebugLevel)
 		} else {
-			liblogger.SetLevel("badger", zap.InfoLevel)
+			liblogger.SetLevel(fullName(name), zap.InfoLevel)
 		}
 		return out, nil
 	}
-	return
 }
@@ -0,0 +1,156 @@
+package libcommand
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type (
+	CaptureMode int
+
+	// 保存输出 用于日志 和 Run.Stdout Run.Stderr
+	// CaptureHeadTail 只保留 前 limit/2 和 后 limit/2 字节
+	captureBuffer struct {
+		mode  CaptureMode
+		limit int
+		head  []byte
+		tail  []byte
+		total int64
+	}
+
+	// 按行回调 不包含换行符 超过 MaxLine 的行 拆分回调 fn 返回后 line 会被复用
+	LineWriter struct {
+		MaxLine int
+		fn      func(line []byte)
+		buf     []byte
+	}
+)
+
+const (
+	CaptureHeadTail CaptureMode = iota
+	CaptureNone
+	CaptureFull
+)
+
+func WithCapture(mode CaptureMode, limit int) Option {
+	return func(policy *Policy) {
+		policy.Capture = mode
+		policy.CaptureLimit = limit
+	}
+}
+
+// 日志中 stdout stderr 字段 最大字节数 超出时 保留首尾
+func WithLogLimit(limit int) Option {
+	return func(policy *Policy) {
+		policy.LogLimit = limit
+	}
+}
+
+// 按行 回调 stdout stderr  stderr 为 false 时是 stdout
+func WithLineHandler(fn func(stderr bool, line []byte)) Option {
+	return func(policy *Policy) {
+		policy.LineHandler = fn
+	}
+}
+
+func (buffer *captureBuffer) Write(p []byte) {
+	buffer.total += int64(len(p))
+	switch buffer.mode {
+	case CaptureNone:
+	case CaptureFull:
+		buffer.head = append(buffer.head, p...)
+	default:
+		half := buffer.limit / 2
+		if n := half - len(buffer.head); n > 0 {
+			if n > len(p) {
+				n = len(p)
+			}
+			buffer.head = append(buffer.head, p[:n]...)
+			p = p[n:]
+		}
+		if len(p) == 0 || half <= 0 {
+			return
+		}
+		buffer.tail = append(buffer.tail, p...)
+		// 超过两倍时 才移动 避免每次写入都复制
+		if len(buffer.tail) > half*2 {
+			copy(buffer.tail, buffer.tail[len(buffer.tail)-half:])
+			buffer.tail = buffer.tail[:half]
+		}
+	}
+}
+
+func (buffer *captureBuffer) Len() int64 {
+	return buffer.total
+}
+
+// 被截断时 中间插入 标记
+func (buffer *captureBuffer) String() string {
+	if buffer.mode == CaptureNone {
+		if buffer.total == 0 {
+			return ""
+		}
+		return fmt.Sprintf("...[%d bytes not captured]...", buffer.total)
+	}
+	tail := buffer.tail
+	if half := buffer.limit / 2; len(tail) > half {
+		tail = tail[len(tail)-half:]
+	}
+	omitted := buffer.total - int64(len(buffer.head)) - int64(len(tail))
+	if omitted <= 0 {
+		return string(buffer.head) + string(tail)
+	}
+	return string(buffer.head) + truncatedMarker(omitted, buffer.total) + string(tail)
+}
+
+// 超过 limit 时 保留首尾 limit 为 0 不截断
+func truncate(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	half := limit / 2
+	return s[:half] + truncatedMarker(int64(len(s)-half*2), int64(len(s))) + s[len(s)-half:]
+}
+
+func truncatedMarker(omitted int64, total int64) string {
+	return fmt.Sprintf("\n...[truncated %d of %d bytes]...\n", omitted, total)
+}
+
+func NewLineWriter(fn func(line []byte)) *LineWriter {
+	return &LineWriter{
+		MaxLine: 1024 * 64,
+		fn:      fn,
+	}
+}
+
+func (w *LineWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for len(p) != 0 {
+		i := bytes.IndexByte(p, '\n')
+		line := p
+		if i == -1 {
+			p = nil
+		} else {
+			line, p = p[:i], p[i+1:]
+		}
+		w.buf = append(w.buf, line...)
+		for w.MaxLine > 0 && len(w.buf) > w.MaxLine {
+			w.fn(w.buf[:w.MaxLine])
+			w.buf = append(w.buf[:0], w.buf[w.MaxLine:]...)
+		}
+		if i != -1 {
+			w.fn(bytes.TrimSuffix(w.buf, []byte{'\r'}))
+			w.buf = w.buf[:0]
+		}
+	}
+	return
+}
+
+// 回调 最后一行 没有换行符 的部分
+func (w *LineWriter) Flush() error {
+	if len(w.buf) != 0 {
+		w.fn(bytes.TrimSuffix(w.buf, []byte{'\r'}))
+		w.buf = w.buf[:0]
+	}
+	return nil
+}
@@ -1,31 +1,123 @@
 package libcommand
 
 import (
+	"sort"
+	"sync"
 	"time"
 
 	liblogger "github.com/otamoe/go-library/logger"
+	libmetrics "github.com/otamoe/go-library/metrics"
 	"go.uber.org/fx"
 )
 
 type (
 	Command struct {
+		metrics libmetrics.Metrics
+		tracer  Tracer
+
+		mux    sync.Mutex
+		names  []*Name
+		nextID uint64
+		runs   map[uint64]*Run
 	}
 )
 
 var logger = liblogger.Get("command")
 
-func (command *Command) Command(name string, worker int, slowQuery time.Duration) *Name {
-	return &Name{
+// opts 设置 资源限制 进程组 环境变量 输出保存 和 排队限制
+func (command *Command) Command(name string, worker int, slowQuery time.Duration, opts ...Option) *Name {
+	n := &Name{
 		worker:    worker,
 		slowQuery: slowQuery,
 		name:      name,
-		workerCH:  make(chan bool, worker),
+		command:   command,
+		policy: Policy{
+			CaptureLimit: 1024 * 64,
+			LogLimit:     1024 * 4,
+		},
+	}
+	for _, o := range opts {
+		o(&n.policy)
+	}
+	n.scheduler = NewScheduler(worker, n.policy.MaxQueue, n.policy.MaxWait)
+	n.scheduler.onChange = func(running int, queued int) {
+		metrics := command.getMetrics()
+		metrics.Gauge("command_in_flight", float64(running), "name", name)
+		metrics.Gauge("command_queued", float64(queued), "name", name)
+	}
+
+	command.mux.Lock()
+	command.names = append(command.names, n)
+	command.mux.Unlock()
+	return n
+}
+
+// 所有 Name 的 运行中 和 排队中 任务
+func (command *Command) Stats() (stats []SchedulerStats) {
+	command.mux.Lock()
+	names := append([]*Name{}, command.names...)
+	command.mux.Unlock()
+	for _, n := range names {
+		stats = append(stats, n.Stats())
 	}
+	return
+}
+
+// 未结束的 Run 按 ID 排序 用于 查看 和 终止
+func (command *Command) Runs() (runs []*Run) {
+	command.mux.Lock()
+	defer command.mux.Unlock()
+	for _, run := range command.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].id < runs[j].id
+	})
+	return
+}
+
+func (command *Command) Lookup(id uint64) (run *Run, ok bool) {
+	command.mux.Lock()
+	defer command.mux.Unlock()
+	run, ok = command.runs[id]
+	return
+}
+
+func (command *Command) add(run *Run) {
+	command.mux.Lock()
+	defer command.mux.Unlock()
+	command.nextID++
+	run.id = command.nextID
+	if command.runs == nil {
+		command.runs = map[uint64]*Run{}
+	}
+	command.runs[run.id] = run
+}
+
+func (command *Command) remove(run *Run) {
+	command.mux.Lock()
+	defer command.mux.Unlock()
+	delete(command.runs, run.id)
+}
+
+// 零值 &Command{} 可以直接使用 不记录 Metrics Tracer
+func (command *Command) getMetrics() libmetrics.Metrics {
+	if command.metrics == nil {
+		return noopMetrics{}
+	}
+	return command.metrics
+}
+
+func (command *Command) getTracer() Tracer {
+	if command.tracer == nil {
+		return noopTracer{}
+	}
+	return command.tracer
 }
 
 func New() fx.Option {
 	return fx.Options(
-		fx.Provide(NewCommand),
+		fx.Provide(NewObservedCommand),
 	)
 }
 
@@ -33,3 +125,13 @@ func NewCommand() *Command {
 	command := &Command{}
 	return command
 }
+
+// fx 注入 Metrics Tracer 没有提供时 不记录
+func NewObservedCommand(inCommand InCommand) *Command {
+	command := &Command{
+		metrics: inCommand.Metrics,
+		tracer:  inCommand.Tracer,
+	}
+	registerHelp(command.getMetrics())
+	return command
+}
@@ -0,0 +1,146 @@
+package libcommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type (
+	// 进程 非成功退出 Signal 不为 0 时 为被信号终止 ExitCode 为 -1
+	ExitError struct {
+		Name     string
+		Args     []string
+		ExitCode int
+		Signal   syscall.Signal
+		// stderr 最后部分
+		Stderr   string
+		Duration time.Duration
+		Err      *exec.ExitError
+	}
+
+	// MaxAttempts 包括第一次 小于 2 不重试
+	// 默认 被信号终止 或 退出码在 ExitCodes 中时 重试 Retryable 不为 nil 时 只使用 Retryable
+	// stdin 不为 nil 且 不是 io.Seeker 时 无法重放 不重试 失败的 stdout stderr 已写入 调用方的 writer
+	RetryPolicy struct {
+		MaxAttempts int
+		Backoff     time.Duration
+		MaxBackoff  time.Duration
+		Signals     bool
+		ExitCodes   []int
+		Retryable   func(err error) bool
+	}
+)
+
+const stderrTailSize = 1024
+
+func (e *ExitError) Error() string {
+	if e.Signal != 0 {
+		return fmt.Sprintf("%s: signal: %s", e.Name, e.Signal)
+	}
+	return fmt.Sprintf("%s: exit status %d", e.Name, e.ExitCode)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// nil 时 为 0 或 1 且 stderr 为空
+func WithSuccessCodes(codes ...int) Option {
+	return func(policy *Policy) {
+		policy.SuccessCodes = append([]int{}, codes...)
+	}
+}
+
+func WithRetry(retry RetryPolicy) Option {
+	return func(policy *Policy) {
+		policy.Retry = retry
+	}
+}
+
+func (policy *Policy) success(code int, stderrLen int64) bool {
+	if policy.SuccessCodes == nil {
+		return code == 0 || (code == 1 && stderrLen == 0)
+	}
+	for _, c := range policy.SuccessCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (retry *RetryPolicy) retryable(err error) bool {
+	if retry.Retryable != nil {
+		return retry.Retryable(err)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	if exitErr.Signal != 0 {
+		return retry.Signals
+	}
+	for _, code := range retry.ExitCodes {
+		if code == exitErr.ExitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回 下次重试前 等待时间  attempt 从 1 开始
+func (retry *RetryPolicy) next(ctx context.Context, err error, attempt int, stdin io.Reader) (delay time.Duration, ok bool) {
+	if err == nil || attempt >= retry.MaxAttempts || ctx.Err() != nil || !retry.retryable(err) {
+		return
+	}
+	if stdin != nil {
+		seeker, isSeeker := stdin.(io.Seeker)
+		if !isSeeker {
+			return
+		}
+		if _, e := seeker.Seek(0, io.SeekStart); e != nil {
+			return
+		}
+	}
+	delay = retry.Backoff
+	for i := 1; i < attempt && delay > 0; i++ {
+		if delay *= 2; retry.MaxBackoff > 0 && delay > retry.MaxBackoff {
+			delay = retry.MaxBackoff
+			break
+		}
+	}
+	return delay, true
+}
+
+// 转换为 ExitError 成功的退出码 返回 nil
+func (name *Name) exitError(err error, args []string, stderr *RunWriter, duration time.Duration) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	e := &ExitError{
+		Name:     name.name,
+		Args:     args,
+		ExitCode: exitErr.ExitCode(),
+		Duration: duration,
+		Err:      exitErr,
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		e.Signal = status.Signal()
+	}
+	if e.Signal == 0 && name.policy.success(e.ExitCode, stderr.Len()) {
+		return nil
+	}
+	e.Stderr = stderr.String()
+	if len(e.Stderr) > stderrTailSize {
+		e.Stderr = e.Stderr[len(e.Stderr)-stderrTailSize:]
+	}
+	e.Stderr = strings.TrimSpace(e.Stderr)
+	return e
+}
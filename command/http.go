@@ -0,0 +1,98 @@
+package libcommand
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	libhttp "github.com/otamoe/go-library/http"
+)
+
+type (
+	RunStatus struct {
+		ID        uint64    `json:"id"`
+		Name      string    `json:"name"`
+		Args      []string  `json:"args"`
+		State     RunState  `json:"state"`
+		PID       int       `json:"pid,omitempty"`
+		StartedAt time.Time `json:"startedAt,omitempty"`
+	}
+)
+
+func (run *Run) Status() RunStatus {
+	return RunStatus{
+		ID:        run.ID(),
+		Name:      run.Name(),
+		Args:      run.Args(),
+		State:     run.State(),
+		PID:       run.PID(),
+		StartedAt: run.StartedAt(),
+	}
+}
+
+// GET 返回 运行中 和 排队中 的 Run  DELETE ?id=&grace= 终止 grace 默认 10s
+func (command *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var res interface{}
+	switch r.Method {
+	case http.MethodGet:
+		statuses := []RunStatus{}
+		for _, run := range command.Runs() {
+			statuses = append(statuses, run.Status())
+		}
+		res = map[string]interface{}{
+			"runs":  statuses,
+			"stats": command.Stats(),
+		}
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		grace := time.Second * 10
+		if s := r.URL.Query().Get("grace"); s != "" {
+			if grace, err = time.ParseDuration(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		run, ok := command.Lookup(id)
+		if !ok {
+			http.Error(w, ErrNotRunning.Error(), http.StatusNotFound)
+			return
+		}
+		status := run.Status()
+		if err = run.Kill(grace); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		res = status
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	b, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+func (command *Command) Handler(path string) libhttp.HandlerFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == path {
+				command.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// 注册到 libhttp 只应注册在 管理端口 或 内网 host
+func WithHTTPHandler(hosts []string, index int, path string) func(command *Command) (out libhttp.OutOption) {
+	return func(command *Command) (out libhttp.OutOption) {
+		return libhttp.WithHandler(hosts, index, command.Handler(path))()
+	}
+}
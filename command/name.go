@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"io"
-	"os/exec"
 	"time"
 
 	"go.uber.org/zap"
@@ -15,90 +14,167 @@ type (
 		name      string
 		worker    int
 		slowQuery time.Duration
-		workerCH  chan bool
+		command   *Command
+		policy    Policy
+		scheduler *Scheduler
 	}
 )
 
 var ErrSlowQuery = errors.New("slow Query")
 
+func (name *Name) Stats() (stats SchedulerStats) {
+	stats = name.scheduler.Stats()
+	stats.Name = name.name
+	return
+}
+
 func (name *Name) Run(ctx context.Context, dir string, stdin io.Reader, stdout io.Writer, stderr io.Writer, args ...string) (run *Run) {
+	return name.start(ctx, dir, stdin, nil, stdout, stderr, args)
+}
+
+// 逐步写入 stdin 关闭后 进程读取到 EOF  进程启动前 写入会阻塞 进程退出后 写入返回 ErrNotRunning
+func (name *Name) RunStdin(ctx context.Context, dir string, stdout io.Writer, stderr io.Writer, args ...string) (run *Run, stdin io.WriteCloser) {
+	pr, pw := io.Pipe()
+	return name.start(ctx, dir, nil, pr, stdout, stderr, args), pw
+}
+
+func (name *Name) start(ctx context.Context, dir string, stdin io.Reader, stdinPipe *io.PipeReader, stdout io.Writer, stderr io.Writer, args []string) (run *Run) {
+	ctx, cancel := context.WithCancel(ctx)
 	run = &Run{
-		name:   name,
-		stdin:  stdin,
-		stdout: &RunWriter{Writer: stdout},
-		stderr: &RunWriter{Writer: stderr},
-		wait:   make(chan struct{}),
-		dir:    dir,
+		name:      name,
+		args:      args,
+		stdin:     stdin,
+		stdinPipe: stdinPipe,
+		stdout:    newRunWriter(stdout, &name.policy, false),
+		stderr:    newRunWriter(stderr, &name.policy, true),
+		wait:      make(chan struct{}),
+		dir:       dir,
+		cancel:    cancel,
+		state:     RunQueued,
 	}
+	name.command.add(run)
 
 	go func() {
 		// 线程结束
 		var err error
+		var attempt int
 		defer close(run.wait)
+		defer cancel()
+		defer name.command.remove(run)
+		defer run.setState(RunExited, nil)
+		if stdinPipe != nil {
+			defer stdinPipe.CloseWithError(ErrNotRunning)
+		}
+
+		// 每次 Run 一个 span 包括所有重试
+		ctx, span := name.command.getTracer().Start(ctx, "command "+name.name)
+		span.SetAttribute("command.name", name.name)
+		span.SetAttribute("command.args", args)
 		defer func() {
 			run.err = err
+			span.SetAttribute("command.attempts", attempt)
+			span.SetAttribute("command.outcome", Outcome(ctx, err))
+			var exitErr *ExitError
+			if errors.As(err, &exitErr) {
+				span.SetAttribute("command.exit_code", exitErr.ExitCode)
+			}
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.End()
 		}()
 
-		select {
-		case name.workerCH <- true:
-			// 写入线程 退出线程
-			defer func() {
-				<-name.workerCH
-			}()
-		case <-ctx.Done():
-			// 已取消
-			err = ctx.Err()
-			return
+		for attempt = 1; ; attempt++ {
+			err = name.run(ctx, run, args, attempt)
+			delay, ok := name.policy.Retry.next(ctx, err, attempt, run.stdin)
+			if !ok || stdinPipe != nil || run.isKilled() {
+				return
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+			run.stdout.reset()
+			run.stderr.reset()
 		}
+	}()
 
-		cmd := exec.CommandContext(ctx, name.name, args...)
-		cmd.Stdin = run.stdin
-		cmd.Stdout = run.stdout
-		cmd.Stderr = run.stderr
-		cmd.Dir = run.dir
+	return
+}
 
-		now := time.Now()
-		if err = cmd.Start(); err == nil {
-			err = cmd.Wait()
-		}
+// 运行一次 返回 ExitError 或 其他错误
+func (name *Name) run(ctx context.Context, run *Run, args []string, attempt int) (err error) {
+	metrics := name.command.getMetrics()
 
-		if !run.kill {
-			if err != nil && err.Error() == "exit status 1" && run.stderr.b.Len() == 0 {
-				err = nil
-			}
+	// 排队 优先级 和 租户 从 ctx 读取
+	var release func()
+	queuedAt := time.Now()
+	release, err = name.scheduler.Acquire(ctx, args)
+	metrics.Histogram("command_queue_wait_seconds", time.Since(queuedAt).Seconds(), "name", name.name)
+	if err != nil {
+		metrics.Counter("command_runs_total", 1, "name", name.name, "outcome", Outcome(ctx, err))
+		logger.Warn(name.name, zap.Error(err), zap.Strings("args", args), zap.String("dir", run.dir))
+		return
+	}
+	defer release()
+
+	cmd := name.policy.command(ctx, name.name, args)
+	cmd.Stdin = run.stdin
+	cmd.Stdout = run.stdout
+	cmd.Stderr = run.stderr
+	cmd.Dir = run.dir
+
+	// 流式 stdin 自己复制 进程退出后 Wait 不会等待 未关闭的 stdin
+	var stdinPipe io.WriteCloser
+	if run.stdinPipe != nil {
+		if stdinPipe, err = cmd.StdinPipe(); err != nil {
+			return
 		}
+	}
 
-		latency := time.Now().Sub(now)
-		if err != nil {
-			logger.Error(
-				name.name,
-				zap.Strings("args", args),
-				zap.String("dir", dir),
-				zap.Duration("latency", latency),
-				zap.String("stdout", run.stdout.b.String()),
-				zap.String("stderr", run.stderr.b.String()),
-			)
-		} else if name.slowQuery != 0 && latency > name.slowQuery {
-			logger.Warn(
-				name.name,
-				zap.Error(ErrSlowQuery),
-				zap.Strings("args", args),
-				zap.String("dir", dir),
-				zap.Duration("latency", latency),
-				zap.String("stdout", run.stdout.b.String()),
-				zap.String("stderr", run.stderr.b.String()),
-			)
-		} else {
-			logger.Info(
-				name.name,
-				zap.Strings("args", args),
-				zap.String("dir", dir),
-				zap.Duration("latency", latency),
-				zap.String("stdout", run.stdout.b.String()),
-				zap.String("stderr", run.stderr.b.String()),
-			)
+	now := time.Now()
+	// 资源限制 在程序运行前 设置 设置失败时 不运行
+	if err = name.policy.start(cmd); err == nil {
+		run.setState(RunRunning, cmd)
+		if stdinPipe != nil {
+			go func() {
+				io.Copy(stdinPipe, run.stdinPipe)
+				stdinPipe.Close()
+			}()
 		}
-	}()
+		stop := name.policy.watch(ctx, cmd)
+		err = cmd.Wait()
+		stop()
+	}
+	run.setState(RunQueued, nil)
+	run.stdout.flush()
+	run.stderr.flush()
+
+	latency := time.Now().Sub(now)
+	err = name.exitError(err, args, run.stderr, latency)
 
+	outcome := Outcome(ctx, err)
+	metrics.Histogram("command_run_seconds", latency.Seconds(), "name", name.name, "outcome", outcome)
+	metrics.Counter("command_runs_total", 1, "name", name.name, "outcome", outcome)
+
+	fields := []zap.Field{
+		zap.Strings("args", args),
+		zap.String("dir", run.dir),
+		zap.Duration("latency", latency),
+		zap.String("stdout", truncate(run.stdout.String(), name.policy.LogLimit)),
+		zap.String("stderr", truncate(run.stderr.String(), name.policy.LogLimit)),
+	}
+	if attempt > 1 {
+		fields = append(fields, zap.Int("attempt", attempt))
+	}
+	if err != nil {
+		logger.Error(name.name, append([]zap.Field{zap.Error(err)}, fields...)...)
+	} else if name.slowQuery != 0 && latency > name.slowQuery {
+		logger.Warn(name.name, append([]zap.Field{zap.Error(ErrSlowQuery)}, fields...)...)
+	} else {
+		logger.Info(name.name, fields...)
+	}
 	return
 }
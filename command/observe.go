@@ -0,0 +1,88 @@
+package libcommand
+
+import (
+	"context"
+	"errors"
+
+	libmetrics "github.com/otamoe/go-library/metrics"
+	"go.uber.org/fx"
+)
+
+type (
+	// 与 OpenTelemetry trace.Tracer 对应 通过适配器 接入 otel 或 其他实现
+	// Start 使用 ctx 中的 父 span 返回包含新 span 的 ctx
+	Tracer interface {
+		Start(ctx context.Context, spanName string) (context.Context, Span)
+	}
+
+	Span interface {
+		SetAttribute(key string, value interface{})
+		RecordError(err error)
+		End()
+	}
+
+	InCommand struct {
+		fx.In
+		Metrics libmetrics.Metrics `optional:"true"`
+		Tracer  Tracer             `optional:"true"`
+	}
+
+	noopTracer  struct{}
+	noopSpan    struct{}
+	noopMetrics struct{}
+)
+
+const (
+	OutcomeSuccess      = "success"
+	OutcomeExitCode     = "exit_code"
+	OutcomeSignal       = "signal"
+	OutcomeCanceled     = "canceled"
+	OutcomeQueueFull    = "queue_full"
+	OutcomeQueueTimeout = "queue_timeout"
+	OutcomeError        = "error"
+)
+
+func (noopTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+
+func (noopMetrics) Counter(name string, value float64, labels ...string)   {}
+func (noopMetrics) Gauge(name string, value float64, labels ...string)     {}
+func (noopMetrics) Histogram(name string, value float64, labels ...string) {}
+
+// 结果分类 用于 command_runs_total 的 outcome 标签
+func Outcome(ctx context.Context, err error) string {
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, ErrQueueFull):
+		return OutcomeQueueFull
+	case errors.Is(err, ErrQueueTimeout):
+		return OutcomeQueueTimeout
+	case ctx.Err() != nil:
+		return OutcomeCanceled
+	case errors.As(err, &exitErr) && exitErr.Signal != 0:
+		return OutcomeSignal
+	case errors.As(err, &exitErr):
+		return OutcomeExitCode
+	default:
+		return OutcomeError
+	}
+}
+
+func registerHelp(metrics libmetrics.Metrics) {
+	registry, ok := metrics.(*libmetrics.Registry)
+	if !ok {
+		return
+	}
+	registry.Help("command_queue_wait_seconds", "command time waiting for a worker slot")
+	registry.Help("command_run_seconds", "command process run time")
+	registry.Help("command_in_flight", "command processes running")
+	registry.Help("command_queued", "command runs waiting for a worker slot")
+	registry.Help("command_runs_total", "command runs by outcome")
+}
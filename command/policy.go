@@ -0,0 +1,145 @@
+package libcommand
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type (
+	// 资源限制 0 为不限制 只在 linux 生效
+	Policy struct {
+		// 虚拟内存 字节 RLIMIT_AS
+		AddressSpace uint64
+		// cpu 时间 RLIMIT_CPU 精度为秒
+		CPUTime time.Duration
+		// 打开文件数 RLIMIT_NOFILE
+		OpenFiles uint64
+		// 写入文件大小 字节 RLIMIT_FSIZE
+		FileSize uint64
+
+		// nice 值 -20 到 19 0 不修改
+		Nice int
+		// io 优先级 class 1 realtime 2 best-effort 3 idle  0 不修改 level 0 到 7
+		IOClass int
+		IOLevel int
+
+		// 使用独立进程组 取消时 kill 整个进程组 子进程不会残留
+		ProcessGroup bool
+
+		// nil 继承全部环境变量 否则只保留列出的 NAME 或 使用 NAME=VALUE 指定值
+		Env []string
+
+		// 输出保存方式 CaptureHeadTail 时 最多保存 CaptureLimit 字节
+		Capture      CaptureMode
+		CaptureLimit int
+		// 日志字段 最大字节数 0 不截断
+		LogLimit    int
+		LineHandler func(stderr bool, line []byte)
+
+		// 排队 限制
+		MaxQueue int
+		MaxWait  time.Duration
+
+		// 成功的退出码 nil 时 为 0 或 1 且 stderr 为空
+		SuccessCodes []int
+		Retry        RetryPolicy
+	}
+
+	Option func(policy *Policy)
+)
+
+func WithAddressSpace(bytes uint64) Option {
+	return func(policy *Policy) {
+		policy.AddressSpace = bytes
+	}
+}
+
+func WithCPUTime(d time.Duration) Option {
+	return func(policy *Policy) {
+		policy.CPUTime = d
+	}
+}
+
+func WithOpenFiles(n uint64) Option {
+	return func(policy *Policy) {
+		policy.OpenFiles = n
+	}
+}
+
+func WithFileSize(bytes uint64) Option {
+	return func(policy *Policy) {
+		policy.FileSize = bytes
+	}
+}
+
+func WithNice(nice int) Option {
+	return func(policy *Policy) {
+		policy.Nice = nice
+	}
+}
+
+func WithIOPriority(class int, level int) Option {
+	return func(policy *Policy) {
+		policy.IOClass = class
+		policy.IOLevel = level
+	}
+}
+
+func WithProcessGroup() Option {
+	return func(policy *Policy) {
+		policy.ProcessGroup = true
+	}
+}
+
+func WithEnv(env ...string) Option {
+	return func(policy *Policy) {
+		policy.Env = append([]string{}, env...)
+	}
+}
+
+func (policy *Policy) command(ctx context.Context, name string, args []string) (cmd *exec.Cmd) {
+	if policy.ProcessGroup {
+		// 取消时 由 watch kill 整个进程组
+		cmd = exec.Command(name, args...)
+	} else {
+		cmd = exec.CommandContext(ctx, name, args...)
+	}
+	cmd.SysProcAttr = policy.sysProcAttr()
+	if policy.Env != nil {
+		cmd.Env = policy.environ()
+	}
+	return
+}
+
+func (policy *Policy) environ() (env []string) {
+	env = []string{}
+	for _, e := range policy.Env {
+		if strings.Contains(e, "=") {
+			env = append(env, e)
+		} else if value, ok := os.LookupEnv(e); ok {
+			env = append(env, e+"="+value)
+		}
+	}
+	return
+}
+
+// 进程组模式 ctx 取消时 kill 整个进程组 返回的函数 在进程结束后调用
+func (policy *Policy) watch(ctx context.Context, cmd *exec.Cmd) (stop func()) {
+	if !policy.ProcessGroup {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killGroup(cmd)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
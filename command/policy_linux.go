@@ -0,0 +1,112 @@
+package libcommand
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func (policy *Policy) sysProcAttr() *syscall.SysProcAttr {
+	if !policy.ProcessGroup {
+		return nil
+	}
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+func (policy *Policy) limited() bool {
+	return policy.AddressSpace != 0 || policy.CPUTime != 0 || policy.OpenFiles != 0 || policy.FileSize != 0 || policy.Nice != 0 || policy.IOClass != 0
+}
+
+// 有资源限制时 通过 ptrace 在 execve 之后 程序运行之前 停止子进程 设置限制 后再继续
+// 设置失败时 结束子进程 并返回错误
+func (policy *Policy) start(cmd *exec.Cmd) (err error) {
+	if !policy.limited() {
+		return cmd.Start()
+	}
+	// ptrace 的 tracer 是 fork 的线程 detach 必须在同一个线程
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	pid := cmd.Process.Pid
+
+	// execve 成功后 子进程 收到 SIGTRAP 停止
+	var status unix.WaitStatus
+	if _, err = unix.Wait4(pid, &status, unix.WALL, nil); err == nil && !status.Stopped() {
+		err = fmt.Errorf("ptrace: unexpected wait status %v", status)
+	}
+	if err == nil {
+		err = policy.apply(pid)
+	}
+	if err != nil {
+		killGroup(cmd)
+		unix.PtraceDetach(pid)
+		cmd.Wait()
+		return
+	}
+	if err = unix.PtraceDetach(pid); err != nil {
+		killGroup(cmd)
+		cmd.Wait()
+		return fmt.Errorf("ptrace detach: %w", err)
+	}
+	return
+}
+
+// 子进程 停止时 通过 prlimit setpriority ioprio_set 设置
+func (policy *Policy) apply(pid int) (err error) {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_AS, policy.AddressSpace},
+		{unix.RLIMIT_CPU, uint64((policy.CPUTime + time.Second - 1) / time.Second)},
+		{unix.RLIMIT_NOFILE, policy.OpenFiles},
+		{unix.RLIMIT_FSIZE, policy.FileSize},
+	}
+	for _, limit := range limits {
+		if limit.value == 0 {
+			continue
+		}
+		if err = unix.Prlimit(pid, limit.resource, &unix.Rlimit{Cur: limit.value, Max: limit.value}, nil); err != nil {
+			return fmt.Errorf("prlimit %d: %w", limit.resource, err)
+		}
+	}
+	if policy.Nice != 0 {
+		if err = unix.Setpriority(unix.PRIO_PROCESS, pid, policy.Nice); err != nil {
+			return fmt.Errorf("setpriority: %w", err)
+		}
+	}
+	if policy.IOClass != 0 {
+		// IOPRIO_WHO_PROCESS = 1  IOPRIO_CLASS_SHIFT = 13
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, 1, uintptr(pid), uintptr(policy.IOClass<<13|policy.IOLevel)); errno != 0 {
+			return fmt.Errorf("ioprio_set: %w", errno)
+		}
+	}
+	return
+}
+
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if err := unix.Kill(-cmd.Process.Pid, unix.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+func signalProcess(cmd *exec.Cmd, group bool, sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok && group {
+		return unix.Kill(-cmd.Process.Pid, s)
+	}
+	return cmd.Process.Signal(sig)
+}
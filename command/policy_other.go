@@ -0,0 +1,28 @@
+//go:build !linux
+
+package libcommand
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func (policy *Policy) sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// 非 linux 不支持 资源限制
+func (policy *Policy) start(cmd *exec.Cmd) (err error) {
+	return cmd.Start()
+}
+
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+func signalProcess(cmd *exec.Cmd, group bool, sig os.Signal) error {
+	return cmd.Process.Signal(sig)
+}
@@ -1,37 +1,191 @@
 package libcommand
 
 import (
-	"bytes"
+	"errors"
 	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type (
+	RunState string
+
 	Run struct {
-		name   *Name
-		stdin  io.Reader
-		stdout *RunWriter
-		stderr *RunWriter
-		dir    string
-		wait   chan struct{}
-		err    error
-		kill   bool
+		id        uint64
+		name      *Name
+		args      []string
+		stdin     io.Reader
+		stdinPipe *io.PipeReader
+		stdout    *RunWriter
+		stderr    *RunWriter
+		dir       string
+		wait      chan struct{}
+		err       error
+		cancel    func()
+
+		mux       sync.Mutex
+		state     RunState
+		cmd       *exec.Cmd
+		startedAt time.Time
+		killed    bool
 	}
 	RunWriter struct {
 		io.Writer
-		b bytes.Buffer
+		b     captureBuffer
+		lines *LineWriter
 	}
 )
 
+func newRunWriter(w io.Writer, policy *Policy, stderr bool) (runWriter *RunWriter) {
+	runWriter = &RunWriter{
+		Writer: w,
+		b:      captureBuffer{mode: policy.Capture, limit: policy.CaptureLimit},
+	}
+	if fn := policy.LineHandler; fn != nil {
+		runWriter.lines = NewLineWriter(func(line []byte) {
+			fn(stderr, line)
+		})
+	}
+	return
+}
+
 func (w *RunWriter) Write(p []byte) (n int, err error) {
-	n, err = w.Writer.Write(p)
-	if n > 0 {
-		if _, err = w.b.Write(p[0:n]); err != nil {
-			return
-		}
+	if w.Writer == nil {
+		n = len(p)
+	} else if n, err = w.Writer.Write(p); n <= 0 {
+		return
+	}
+	w.b.Write(p[0:n])
+	if w.lines != nil {
+		w.lines.Write(p[0:n])
+	}
+	return
+}
+
+// 写入的总字节数 包括未保存的部分
+func (w *RunWriter) Len() int64 {
+	return w.b.Len()
+}
+
+// 保存的输出 被截断时 中间有标记
+func (w *RunWriter) String() string {
+	return w.b.String()
+}
+
+// 重试前 清空保存的输出
+func (w *RunWriter) reset() {
+	w.flush()
+	w.b = captureBuffer{mode: w.b.mode, limit: w.b.limit}
+}
+
+func (w *RunWriter) flush() {
+	if w.lines != nil {
+		w.lines.Flush()
+	}
+}
+
+const (
+	RunQueued  RunState = "queued"
+	RunRunning RunState = "running"
+	RunExited  RunState = "exited"
+)
+
+var ErrNotRunning = errors.New("command is not running")
+
+// 同一个 Command 中 唯一
+func (run *Run) ID() uint64 {
+	return run.id
+}
+
+func (run *Run) Name() string {
+	return run.name.name
+}
+
+func (run *Run) Args() []string {
+	return run.args
+}
+
+// 排队中 重试等待中 为 RunQueued
+func (run *Run) State() RunState {
+	run.mux.Lock()
+	defer run.mux.Unlock()
+	return run.state
+}
+
+// 未运行时 为 0
+func (run *Run) PID() int {
+	run.mux.Lock()
+	defer run.mux.Unlock()
+	if run.cmd == nil || run.cmd.Process == nil {
+		return 0
+	}
+	return run.cmd.Process.Pid
+}
+
+// 最近一次 进程启动时间 未启动时 为零值
+func (run *Run) StartedAt() time.Time {
+	run.mux.Lock()
+	defer run.mux.Unlock()
+	return run.startedAt
+}
+
+// 发送信号 使用进程组时 发送到整个进程组
+func (run *Run) Signal(sig os.Signal) (err error) {
+	run.mux.Lock()
+	defer run.mux.Unlock()
+	if run.state != RunRunning || run.cmd == nil || run.cmd.Process == nil {
+		return ErrNotRunning
+	}
+	return signalProcess(run.cmd, run.name.policy.ProcessGroup, sig)
+}
+
+// 排队中 直接取消 运行中 先发送 SIGTERM  grace 后仍未退出 再 SIGKILL  不会再重试
+// 子进程 需要 WithProcessGroup 才会一起结束 否则 Wait 会等待 子进程 关闭输出
+func (run *Run) Kill(grace time.Duration) (err error) {
+	run.mux.Lock()
+	run.killed = true
+	state := run.state
+	run.mux.Unlock()
+	if state == RunExited {
+		return ErrNotRunning
+	}
+	if state == RunQueued || grace <= 0 {
+		run.cancel()
+		return
+	}
+	if e := run.Signal(syscall.SIGTERM); e != nil {
+		run.cancel()
+		return
+	}
+	t := time.NewTimer(grace)
+	defer t.Stop()
+	select {
+	case <-run.wait:
+	case <-t.C:
+		run.cancel()
 	}
 	return
 }
 
+func (run *Run) setState(state RunState, cmd *exec.Cmd) {
+	run.mux.Lock()
+	defer run.mux.Unlock()
+	run.state = state
+	run.cmd = cmd
+	if state == RunRunning {
+		run.startedAt = time.Now()
+	}
+}
+
+func (run *Run) isKilled() bool {
+	run.mux.Lock()
+	defer run.mux.Unlock()
+	return run.killed
+}
+
 func (run *Run) Err() (err error) {
 	<-run.wait
 	return run.err
@@ -39,3 +193,12 @@ func (run *Run) Err() (err error) {
 func (run *Run) Wait() chan struct{} {
 	return run.wait
 }
+
+// 需要在 Err 或 Wait 之后调用
+func (run *Run) Stdout() string {
+	return run.stdout.String()
+}
+
+func (run *Run) Stderr() string {
+	return run.stderr.String()
+}
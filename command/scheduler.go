@@ -0,0 +1,298 @@
+package libcommand
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	Priority int
+
+	// 运行中 或 排队中 的任务
+	Job struct {
+		ID         uint64    `json:"id"`
+		Args       []string  `json:"args"`
+		Tenant     string    `json:"tenant"`
+		Priority   Priority  `json:"priority"`
+		EnqueuedAt time.Time `json:"enqueuedAt"`
+		StartedAt  time.Time `json:"startedAt,omitempty"`
+	}
+
+	SchedulerStats struct {
+		Name    string `json:"name"`
+		Slots   int    `json:"slots"`
+		Running []Job  `json:"running"`
+		Queued  []Job  `json:"queued"`
+	}
+
+	// 替代 信号量 优先级高的先运行 同优先级 运行中任务最少的租户先运行 同租户 先进先出
+	Scheduler struct {
+		slots    int
+		maxQueue int
+		maxWait  time.Duration
+
+		mux     sync.Mutex
+		nextID  uint64
+		queued  int
+		queues  map[Priority]map[string][]*waiter
+		running map[uint64]*Job
+		tenants map[string]int
+
+		// 运行中 和 排队中 数量变化时 调用 持有锁
+		onChange func(running int, queued int)
+	}
+
+	waiter struct {
+		job     Job
+		granted chan struct{}
+	}
+
+	priorityKey struct{}
+	tenantKey   struct{}
+)
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+	PriorityUrgent Priority = 2
+)
+
+var ErrQueueFull = errors.New("command queue is full")
+var ErrQueueTimeout = errors.New("command queue wait timeout")
+
+// 默认 PriorityNormal
+func ContextWithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// 默认 "" 所有未设置的 共用一个租户
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func PriorityFromContext(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority)
+	return priority
+}
+
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// 最多排队数量 超出时 直接返回 ErrQueueFull  0 不限制
+func WithMaxQueue(n int) Option {
+	return func(policy *Policy) {
+		policy.MaxQueue = n
+	}
+}
+
+// 最长排队时间 超出时 返回 ErrQueueTimeout  0 不限制
+func WithMaxWait(d time.Duration) Option {
+	return func(policy *Policy) {
+		policy.MaxWait = d
+	}
+}
+
+func NewScheduler(slots int, maxQueue int, maxWait time.Duration) *Scheduler {
+	if slots <= 0 {
+		slots = 1
+	}
+	return &Scheduler{
+		slots:    slots,
+		maxQueue: maxQueue,
+		maxWait:  maxWait,
+		queues:   map[Priority]map[string][]*waiter{},
+		running:  map[uint64]*Job{},
+		tenants:  map[string]int{},
+	}
+}
+
+// 阻塞直到获得运行位置 优先级 和 租户 从 ctx 读取 成功时 运行结束后 必须调用 release
+func (scheduler *Scheduler) Acquire(ctx context.Context, args []string) (release func(), err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	scheduler.mux.Lock()
+	scheduler.nextID++
+	w := &waiter{
+		job: Job{
+			ID:         scheduler.nextID,
+			Args:       args,
+			Tenant:     TenantFromContext(ctx),
+			Priority:   PriorityFromContext(ctx),
+			EnqueuedAt: time.Now(),
+		},
+		granted: make(chan struct{}),
+	}
+
+	// 有空闲 且无人排队 直接运行
+	if scheduler.queued == 0 && len(scheduler.running) < scheduler.slots {
+		scheduler.start(w)
+		scheduler.changed()
+		scheduler.mux.Unlock()
+		return scheduler.releaser(w.job.ID), nil
+	}
+	if scheduler.maxQueue > 0 && scheduler.queued >= scheduler.maxQueue {
+		scheduler.mux.Unlock()
+		return nil, ErrQueueFull
+	}
+	tenants, ok := scheduler.queues[w.job.Priority]
+	if !ok {
+		tenants = map[string][]*waiter{}
+		scheduler.queues[w.job.Priority] = tenants
+	}
+	tenants[w.job.Tenant] = append(tenants[w.job.Tenant], w)
+	scheduler.queued++
+	scheduler.changed()
+	scheduler.mux.Unlock()
+
+	var timeout <-chan time.Time
+	if scheduler.maxWait > 0 {
+		t := time.NewTimer(scheduler.maxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-w.granted:
+		return scheduler.releaser(w.job.ID), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrQueueTimeout
+	}
+
+	scheduler.mux.Lock()
+	defer scheduler.mux.Unlock()
+	select {
+	case <-w.granted:
+		// 取消的同时 已获得位置 交给下一个
+		scheduler.finish(w.job.ID)
+	default:
+		scheduler.remove(w)
+		scheduler.changed()
+	}
+	return
+}
+
+func (scheduler *Scheduler) Stats() (stats SchedulerStats) {
+	scheduler.mux.Lock()
+	defer scheduler.mux.Unlock()
+	stats.Slots = scheduler.slots
+	stats.Running = make([]Job, 0, len(scheduler.running))
+	for _, job := range scheduler.running {
+		stats.Running = append(stats.Running, *job)
+	}
+	stats.Queued = make([]Job, 0, scheduler.queued)
+	for _, tenants := range scheduler.queues {
+		for _, waiters := range tenants {
+			for _, w := range waiters {
+				stats.Queued = append(stats.Queued, w.job)
+			}
+		}
+	}
+	sort.Slice(stats.Running, func(i, j int) bool {
+		return stats.Running[i].ID < stats.Running[j].ID
+	})
+	sort.Slice(stats.Queued, func(i, j int) bool {
+		if stats.Queued[i].Priority != stats.Queued[j].Priority {
+			return stats.Queued[i].Priority > stats.Queued[j].Priority
+		}
+		return stats.Queued[i].ID < stats.Queued[j].ID
+	})
+	return
+}
+
+func (scheduler *Scheduler) releaser(id uint64) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			scheduler.mux.Lock()
+			defer scheduler.mux.Unlock()
+			scheduler.finish(id)
+		})
+	}
+}
+
+func (scheduler *Scheduler) start(w *waiter) {
+	w.job.StartedAt = time.Now()
+	scheduler.running[w.job.ID] = &w.job
+	scheduler.tenants[w.job.Tenant]++
+	close(w.granted)
+}
+
+func (scheduler *Scheduler) finish(id uint64) {
+	job, ok := scheduler.running[id]
+	if !ok {
+		return
+	}
+	delete(scheduler.running, id)
+	if scheduler.tenants[job.Tenant]--; scheduler.tenants[job.Tenant] <= 0 {
+		delete(scheduler.tenants, job.Tenant)
+	}
+	scheduler.dispatch()
+	scheduler.changed()
+}
+
+func (scheduler *Scheduler) changed() {
+	if scheduler.onChange != nil {
+		scheduler.onChange(len(scheduler.running), scheduler.queued)
+	}
+}
+
+func (scheduler *Scheduler) remove(w *waiter) {
+	tenants := scheduler.queues[w.job.Priority]
+	waiters := tenants[w.job.Tenant]
+	for i, v := range waiters {
+		if v == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			scheduler.queued--
+			break
+		}
+	}
+	scheduler.setWaiters(w.job.Priority, w.job.Tenant, waiters)
+}
+
+func (scheduler *Scheduler) setWaiters(priority Priority, tenant string, waiters []*waiter) {
+	tenants := scheduler.queues[priority]
+	if len(waiters) != 0 {
+		tenants[tenant] = waiters
+		return
+	}
+	delete(tenants, tenant)
+	if len(tenants) == 0 {
+		delete(scheduler.queues, priority)
+	}
+}
+
+// 有空闲位置时 按优先级 租户 顺序 唤醒排队的任务
+func (scheduler *Scheduler) dispatch() {
+	for scheduler.queued != 0 && len(scheduler.running) < scheduler.slots {
+		var top Priority
+		first := true
+		for priority := range scheduler.queues {
+			if first || priority > top {
+				top, first = priority, false
+			}
+		}
+
+		var tenant string
+		var next *waiter
+		for t, waiters := range scheduler.queues[top] {
+			w := waiters[0]
+			if next == nil ||
+				scheduler.tenants[t] < scheduler.tenants[tenant] ||
+				(scheduler.tenants[t] == scheduler.tenants[tenant] && w.job.ID < next.job.ID) {
+				tenant, next = t, w
+			}
+		}
+
+		scheduler.setWaiters(top, tenant, scheduler.queues[top][tenant][1:])
+		scheduler.queued--
+		scheduler.start(next)
+	}
+}
@@ -81,7 +81,7 @@ func (b *Buffer) Write(p []byte) (n int, err error) {
 		}
 	}
 
-	err = b.event.Progress(b.total, b.loaded, b.speed)
+	err = b.event.Progress(b.loaded, b.total, b.speed)
 	if err != nil {
 		b.logger.Error("progress", zap.Error(err))
 	}
@@ -0,0 +1,86 @@
+package libffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	libjob "github.com/otamoe/go-library/job"
+	liblogger "github.com/otamoe/go-library/logger"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type (
+	// 任务 内容
+	JobPayload struct {
+		Type Type     `json:"type"`
+		Args []string `json:"args"`
+	}
+
+	// 任务 进度 写入 Job.Progress
+	JobProgress struct {
+		Loaded time.Duration `json:"loaded"`
+		Total  time.Duration `json:"total"`
+		Speed  float32       `json:"speed"`
+	}
+
+	// 进度 写入 租约
+	jobEvent struct {
+		ctx   context.Context
+		lease *libjob.Lease
+	}
+)
+
+const JobQueue = "ffmpeg"
+
+var jobLogger = liblogger.Get("ffmpeg.job")
+
+// 加入 持久化队列 通过 Queue.Get 查询状态 进度 和 结果
+func (ffmpegCommand *FFmpegCommand) Enqueue(ctx context.Context, queue *libjob.Queue, typ Type, args []string, opts ...libjob.EnqueueOption) (job *libjob.Job, err error) {
+	var b []byte
+	if b, err = json.Marshal(JobPayload{Type: typ, Args: args}); err != nil {
+		return
+	}
+	return queue.Enqueue(ctx, JobQueue, b, opts...)
+}
+
+func (ffmpegCommand *FFmpegCommand) JobHandler() libjob.Handler {
+	return func(ctx context.Context, lease *libjob.Lease) (result []byte, err error) {
+		var payload JobPayload
+		if err = json.Unmarshal(lease.Job().Payload, &payload); err != nil {
+			return nil, libjob.Permanent(err)
+		}
+		return ffmpegCommand.Get(ctx, payload.Type, payload.Args, &jobEvent{ctx: ctx, lease: lease})
+	}
+}
+
+// 注册 ffmpeg 队列 的 worker 需要 libjob.New()
+func WithJobWorker(concurrency int) fx.Option {
+	return fx.Provide(func(ffmpegCommand *FFmpegCommand) (out libjob.OutWorker) {
+		return libjob.RegisterWorker(libjob.Worker{
+			Queue:       JobQueue,
+			Concurrency: concurrency,
+			Handler:     ffmpegCommand.JobHandler(),
+		})()
+	})
+}
+
+func (event *jobEvent) Start(dir string) (err error) {
+	return
+}
+
+// 取消 由 worker 心跳 结束 ctx
+// 返回的错误 会中断 ffmpeg 只返回 取消 和 租约丢失 其他错误 (例如 存储暂时不可用) 只记录 心跳 会续期
+func (event *jobEvent) Progress(loaded time.Duration, total time.Duration, speed float32) (err error) {
+	if err = event.lease.Progress(event.ctx, JobProgress{Loaded: loaded, Total: total, Speed: speed}); err == nil || errors.Is(err, libjob.ErrCanceled) || errors.Is(err, libjob.ErrLeaseLost) {
+		return
+	}
+	jobLogger.Warn("progress", zap.String("id", event.lease.Job().ID), zap.Error(err))
+	return nil
+}
+
+func (event *jobEvent) Complete(out []byte, err error) (rerr error) {
+	return err
+}
@@ -177,24 +177,20 @@ func Server(server *ghandler.Server, path string) func() (out OutOption) {
 			path = "/"
 		}
 		playgroundHandler := playground.Handler("GraphQL playground", "/")
-		out.Option = func(graphql *Graphql) error {
+		out.Option = func(graphql *Graphql) (err error) {
+			router := libhttp.NewRouter()
+			if err = router.Handle("", path, server); err != nil {
+				return
+			}
+			if err = router.Handle("", "/playground", playgroundHandler); err != nil {
+				return
+			}
 			graphql.Handlers = append(graphql.Handlers, Handler{
-				Handler: func(next http.Handler) http.Handler {
-					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-						switch r.URL.Path {
-						case path:
-							server.ServeHTTP(w, r)
-						case "/playground":
-							playgroundHandler.ServeHTTP(w, r)
-						default:
-							next.ServeHTTP(w, r)
-						}
-					})
-				},
-				Name:  "server",
-				Index: 1000,
+				Handler: router.Handler,
+				Name:    "server",
+				Index:   1000,
 			})
-			return nil
+			return
 		}
 		return
 	}
@@ -238,10 +234,12 @@ func Recover() func(ctx context.Context, err interface{}) error {
 	}
 }
 
-func NewGraphql(inOptions InOptions) (graphql *Graphql, httpOutOption libhttp.OutOption) {
+func NewGraphql(inOptions InOptions) (graphql *Graphql, httpOutOption libhttp.OutOption, err error) {
 	graphql = &Graphql{}
 	for _, o := range inOptions.Options {
-		o(graphql)
+		if err = o(graphql); err != nil {
+			return
+		}
 	}
 	// 控制器 排序
 	sort.Slice(graphql.Handlers, func(i, j int) bool {
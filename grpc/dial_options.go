@@ -81,8 +81,10 @@ func NewClientConn(ctx context.Context, inDialtOptions InDialOptions, inClients
 		return
 	}
 
+	watchCtx, cancel := context.WithCancel(context.Background())
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) (err error) {
+			go watchClientConn(watchCtx, clientConn)
 			for _, c := range inClients.Clients {
 				if err = c(ctx, clientConn); err != nil {
 					return
@@ -91,6 +93,7 @@ func NewClientConn(ctx context.Context, inDialtOptions InDialOptions, inClients
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
+			cancel()
 			return clientConn.Close()
 		},
 	})
@@ -25,6 +25,7 @@ func NewClient() fx.Option {
 	return fx.Options(
 		fx.Provide(DialOption(grpc.WithMaxHeaderListSize(1024*4))),
 		fx.Provide(NewClientConn),
+		fx.Provide(HealthChecker("grpc.client")),
 		fx.Provide(NewExtendedDialOptions),
 	)
 }
@@ -0,0 +1,111 @@
+package libgrpc
+
+import (
+	"context"
+	"fmt"
+
+	libhealth "github.com/otamoe/go-library/health"
+	liblogger "github.com/otamoe/go-library/logger"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// 标准 grpc 健康服务 服务名 "" 为整体就绪状态 每个检查器名称 为单独的服务
+// 状态 按 health.interval 定时刷新
+func WithHealthServer() fx.Option {
+	return fx.Options(
+		fx.Provide(NewHealthServer),
+		fx.Provide(func(healthServer *health.Server) (out OutServer) {
+			return RegisterServer(func(s *grpc.Server) (err error) {
+				healthpb.RegisterHealthServer(s, healthServer)
+				return
+			})()
+		}),
+	)
+}
+
+func NewHealthServer(lc fx.Lifecycle, registry *libhealth.Registry) (healthServer *health.Server) {
+	healthServer = health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			go registry.Watch(ctx, viper.GetDuration("health.interval"), func(report *libhealth.Report) {
+				for _, result := range report.Results {
+					healthServer.SetServingStatus(result.Name, servingStatus(result.Healthy))
+				}
+				healthServer.SetServingStatus("", servingStatus(report.Healthy))
+			})
+			return nil
+		},
+		OnStop: func(_ context.Context) error {
+			cancel()
+			// 所有服务 设置为 NOT_SERVING 通知 Watch 的客户端
+			healthServer.Shutdown()
+			return nil
+		},
+	})
+	return
+}
+
+func servingStatus(healthy bool) healthpb.HealthCheckResponse_ServingStatus {
+	if healthy {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// 客户端连接 检查器 Idle 时 触发连接 Connecting 时 等待状态变化
+func HealthChecker(name string) func(clientConn *grpc.ClientConn) (out libhealth.OutChecker) {
+	return func(clientConn *grpc.ClientConn) (out libhealth.OutChecker) {
+		out.Checker = libhealth.Checker{
+			Name:  name,
+			Check: ClientConnCheck(clientConn),
+		}
+		return
+	}
+}
+
+func ClientConnCheck(clientConn *grpc.ClientConn) libhealth.Check {
+	return func(ctx context.Context) (err error) {
+		for {
+			state := clientConn.GetState()
+			switch state {
+			case connectivity.Ready:
+				return nil
+			case connectivity.Shutdown, connectivity.TransientFailure:
+				return fmt.Errorf("grpc client connection %s: %s", clientConn.Target(), state)
+			case connectivity.Idle:
+				clientConn.Connect()
+			}
+			if !clientConn.WaitForStateChange(ctx, state) {
+				return fmt.Errorf("grpc client connection %s: %s: %w", clientConn.Target(), state, ctx.Err())
+			}
+		}
+	}
+}
+
+// 记录 连接状态 变化 直到 ctx 结束 或 连接关闭
+func watchClientConn(ctx context.Context, clientConn *grpc.ClientConn) {
+	logger := liblogger.Get("grpc.client")
+	state := clientConn.GetState()
+	for state != connectivity.Shutdown {
+		if !clientConn.WaitForStateChange(ctx, state) {
+			return
+		}
+		next := clientConn.GetState()
+		fields := []zap.Field{zap.String("target", clientConn.Target()), zap.String("from", state.String()), zap.String("to", next.String())}
+		if next == connectivity.TransientFailure {
+			logger.Warn("state", fields...)
+		} else {
+			logger.Info("state", fields...)
+		}
+		state = next
+	}
+}
@@ -0,0 +1,292 @@
+package libhealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	libhttp "github.com/otamoe/go-library/http"
+	libviper "github.com/otamoe/go-library/viper"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+)
+
+type (
+	Check func(ctx context.Context) error
+
+	Checker struct {
+		Name string
+		// 默认只影响 /readyz  Liveness 为 true 时 也影响 /healthz
+		Liveness bool
+		// 0 时使用 health.timeout
+		Timeout time.Duration
+		Check   Check
+	}
+
+	InCheckers struct {
+		fx.In
+		Checkers []Checker `group:"healthCheckers"`
+	}
+
+	OutChecker struct {
+		fx.Out
+		Checker Checker `group:"healthCheckers"`
+	}
+
+	Result struct {
+		Name      string        `json:"name"`
+		Healthy   bool          `json:"healthy"`
+		Error     string        `json:"error,omitempty"`
+		Latency   time.Duration `json:"latency"`
+		CheckedAt time.Time     `json:"checkedAt"`
+	}
+
+	Report struct {
+		Healthy bool      `json:"healthy"`
+		Results []*Result `json:"results"`
+	}
+
+	// 结果缓存 CacheTTL 内不重复检查 探针频繁请求时 不会压垮后端
+	Registry struct {
+		Timeout  time.Duration
+		CacheTTL time.Duration
+
+		mux     sync.RWMutex
+		entries map[string]*entry
+	}
+
+	entry struct {
+		checker Checker
+		mux     sync.Mutex
+		result  *Result
+	}
+)
+
+var ErrDuplicateChecker = errors.New("health checker already registered")
+
+func init() {
+	libviper.SetDefault("health.timeout", time.Second*3, "health check timeout per checker")
+	libviper.SetDefault("health.cacheTTL", time.Second*2, "health check result cache ttl")
+	libviper.SetDefault("health.interval", time.Second*10, "health check interval for grpc health service")
+}
+
+func New() fx.Option {
+	return fx.Options(
+		fx.Provide(NewRegistry),
+	)
+}
+
+func NewRegistry(inCheckers InCheckers) (registry *Registry, err error) {
+	registry = &Registry{
+		Timeout:  viper.GetDuration("health.timeout"),
+		CacheTTL: viper.GetDuration("health.cacheTTL"),
+		entries:  map[string]*entry{},
+	}
+	for _, checker := range inCheckers.Checkers {
+		if err = registry.Register(checker); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func RegisterChecker(checker Checker) func() (out OutChecker) {
+	return func() (out OutChecker) {
+		out.Checker = checker
+		return
+	}
+}
+
+func (registry *Registry) Register(checker Checker) error {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	if _, ok := registry.entries[checker.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateChecker, checker.Name)
+	}
+	registry.entries[checker.Name] = &entry{checker: checker}
+	return nil
+}
+
+func (registry *Registry) Unregister(name string) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	delete(registry.entries, name)
+}
+
+// 检查器 名称 排序
+func (registry *Registry) Names() (names []string) {
+	registry.mux.RLock()
+	defer registry.mux.RUnlock()
+	for name := range registry.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// 存活检查 只运行 Liveness 检查器
+func (registry *Registry) Liveness() *Report {
+	return registry.run(true)
+}
+
+// 就绪检查 运行所有检查器
+func (registry *Registry) Readiness() *Report {
+	return registry.run(false)
+}
+
+// 单个检查器
+func (registry *Registry) Check(name string) (result *Result, ok bool) {
+	registry.mux.RLock()
+	e, ok := registry.entries[name]
+	registry.mux.RUnlock()
+	if !ok {
+		return
+	}
+	return registry.check(e), true
+}
+
+func (registry *Registry) run(liveness bool) (report *Report) {
+	registry.mux.RLock()
+	entries := make([]*entry, 0, len(registry.entries))
+	for _, e := range registry.entries {
+		if !liveness || e.checker.Liveness {
+			entries = append(entries, e)
+		}
+	}
+	registry.mux.RUnlock()
+
+	report = &Report{Healthy: true, Results: make([]*Result, len(entries))}
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			report.Results[i] = registry.check(e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	sort.Slice(report.Results, func(i, j int) bool {
+		return report.Results[i].Name < report.Results[j].Name
+	})
+	for _, result := range report.Results {
+		report.Healthy = report.Healthy && result.Healthy
+	}
+	return
+}
+
+// 同一检查器 同时只运行一次 其他请求等待后 使用缓存
+func (registry *Registry) check(e *entry) *Result {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.result != nil && time.Since(e.result.CheckedAt) < registry.CacheTTL {
+		return e.result
+	}
+
+	timeout := e.checker.Timeout
+	if timeout <= 0 {
+		timeout = registry.Timeout
+	}
+	// 结果会被缓存 不使用 请求的 ctx 避免请求取消时 缓存失败结果
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	start := time.Now()
+	err := callCheck(ctx, e.checker.Check)
+	result := &Result{
+		Name:      e.checker.Name,
+		Healthy:   err == nil,
+		Latency:   time.Since(start),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	e.result = result
+	return result
+}
+
+// 检查函数 不响应 ctx 时 超时后直接返回
+func callCheck(ctx context.Context, check Check) (err error) {
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- fmt.Errorf("health check panic: %v", r)
+			}
+		}()
+		errc <- check(ctx)
+	}()
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// 定时检查 结果回调 直到 ctx 结束
+func (registry *Registry) Watch(ctx context.Context, interval time.Duration, fn func(report *Report)) {
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		fn(registry.Readiness())
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (registry *Registry) serve(w http.ResponseWriter, r *http.Request, report *Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	b, _ := json.Marshal(report)
+	w.Write(b)
+}
+
+// /healthz 存活  /readyz 就绪  不健康时 返回 503
+func (registry *Registry) Handler() libhttp.HandlerFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				switch r.URL.Path {
+				case "/healthz":
+					registry.serve(w, r, registry.Liveness())
+					return
+				case "/readyz":
+					registry.serve(w, r, registry.Readiness())
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// 注册到 libhttp
+func WithHTTPHandler(hosts []string, index int) func(registry *Registry) (out libhttp.OutOption) {
+	return func(registry *Registry) (out libhttp.OutOption) {
+		return libhttp.WithHandler(hosts, index, registry.Handler())()
+	}
+}
@@ -0,0 +1,154 @@
+package libhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	libviper "github.com/otamoe/go-library/viper"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type (
+	// ACME 自动证书 支持 HTTP-01 和 TLS-ALPN-01
+	// 允许签发的域名 为 Hosts 和 Server.Router 精确注册的 host (包括运行中注册的) 首次请求时签发 到期前 RenewBefore 自动续期
+	// 通配 和 正则 host 可以匹配任意数量的域名 不会签发 需要的域名 加入 Hosts
+	ACME struct {
+		DirectoryURL string
+		Email        string
+		Cache        autocert.Cache
+		Hosts        []string
+		RenewBefore  time.Duration
+
+		// HTTP-01 验证 和 跳转 https 的监听地址 "" 不监听 只使用 TLS-ALPN-01
+		HTTPAddr string
+
+		// 连接 ACME 服务器 例如 测试时 信任本地 ACME 服务器的证书
+		HTTPClient *http.Client
+
+		manager *autocert.Manager
+	}
+)
+
+var ErrACMEHost = errors.New("http acme host not allowed")
+
+func init() {
+	libviper.SetDefault("http.acme.directoryURL", autocert.DefaultACMEDirectory, "http acme directory url")
+	libviper.SetDefault("http.acme.email", "", "http acme account email")
+	libviper.SetDefault("http.acme.cacheDir", "acme", "http acme certificate cache directory")
+	libviper.SetDefault("http.acme.httpAddr", ":80", "http acme http-01 challenge listen address")
+	libviper.SetDefault("http.acme.renewBefore", time.Hour*24*30, "http acme renew certificates before expiry")
+}
+
+// 从配置 读取 cache 为 nil 时 使用 http.acme.cacheDir 目录
+func NewACME(cache autocert.Cache) *ACME {
+	if cache == nil {
+		cache = autocert.DirCache(viper.GetString("http.acme.cacheDir"))
+	}
+	return &ACME{
+		DirectoryURL: viper.GetString("http.acme.directoryURL"),
+		Email:        viper.GetString("http.acme.email"),
+		Cache:        cache,
+		HTTPAddr:     viper.GetString("http.acme.httpAddr"),
+		RenewBefore:  viper.GetDuration("http.acme.renewBefore"),
+	}
+}
+
+// 未设置 TLSConfig 时 使用 ACME 证书 替代 自签名证书
+func WithACME(acme *ACME) func() (out OutOption) {
+	return func() (out OutOption) {
+		out.Option = func(server *Server) error {
+			server.ACME = acme
+			return nil
+		}
+		return
+	}
+}
+
+// 证书缓存在 http.acme.cacheDir 目录
+func WithACMEDir() func() (out OutOption) {
+	return func() (out OutOption) {
+		return WithACME(NewACME(nil))()
+	}
+}
+
+func (a *ACME) Manager() *autocert.Manager {
+	return a.manager
+}
+
+func (a *ACME) init(server *Server) {
+	a.manager = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       a.Cache,
+		HostPolicy:  a.hostPolicy(server),
+		RenewBefore: a.RenewBefore,
+		Email:       a.Email,
+		Client: &acme.Client{
+			DirectoryURL: a.DirectoryURL,
+			HTTPClient:   a.HTTPClient,
+		},
+	}
+}
+
+func (a *ACME) hostPolicy(server *Server) autocert.HostPolicy {
+	return func(_ context.Context, host string) error {
+		host = strings.ToLower(host)
+		for _, v := range a.Hosts {
+			if strings.ToLower(v) == host {
+				return nil
+			}
+		}
+		if server.Router != nil && server.Router.HasExact(host) {
+			return nil
+		}
+		return ErrACMEHost
+	}
+}
+
+// 包含 acme-tls/1 协议
+func (a *ACME) tlsConfig() *tls.Config {
+	return a.manager.TLSConfig()
+}
+
+// HTTP-01 验证 其他请求 跳转到 https
+func (a *ACME) httpServer() *http.Server {
+	return &http.Server{
+		Addr:              a.HTTPAddr,
+		Handler:           a.manager.HTTPHandler(nil),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+}
+
+func (a *ACME) lifecycle(lc fx.Lifecycle) {
+	if a.HTTPAddr == "" {
+		return
+	}
+	server := a.httpServer()
+	lc.Append(fx.Hook{
+		OnStart: func(c context.Context) error {
+			errc := make(chan error, 1)
+			go func() {
+				if e := server.ListenAndServe(); e != http.ErrServerClosed {
+					errc <- e
+				}
+			}()
+			t := time.NewTimer(time.Millisecond * 200)
+			defer t.Stop()
+			select {
+			case <-t.C:
+				return nil
+			case err := <-errc:
+				return err
+			}
+		},
+		OnStop: func(c context.Context) error {
+			return server.Shutdown(c)
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package libhttp
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// 模拟 ACME 服务器 拒绝 创建账户 只记录 请求数
+func newFakeACME(t *testing.T) (server *httptest.Server, requests *int32) {
+	requests = new(int32)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   server.URL + "/nonce",
+			"newAccount": server.URL + "/account",
+			"newOrder":   server.URL + "/order",
+			"revokeCert": server.URL + "/revoke",
+			"keyChange":  server.URL + "/key",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Replay-Nonce", "nonce")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Replay-Nonce", "nonce")
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"type":   "urn:ietf:params:acme:error:malformed",
+			"detail": "fake acme",
+		})
+	})
+	server = httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	return
+}
+
+func TestACMEHostPolicy(t *testing.T) {
+	fake, requests := newFakeACME(t)
+
+	server := &Server{Router: NewHostRouter(http.NotFoundHandler())}
+	next := func(next http.Handler) http.Handler { return next }
+	for _, host := range []string{"www.example.com", "*.example.com", `~^api\d+\.example\.org$`} {
+		if err := server.Router.Register(host, 0, next); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &ACME{
+		DirectoryURL: fake.URL + "/directory",
+		Cache:        autocert.DirCache(t.TempDir()),
+		Hosts:        []string{"Static.Example.net"},
+		HTTPClient:   fake.Client(),
+	}
+	a.init(server)
+
+	hello := func(name string) *tls.ClientHelloInfo {
+		return &tls.ClientHelloInfo{ServerName: name, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
+	}
+
+	// 通配 和 正则 不签发 也不访问 ACME 服务器
+	for _, name := range []string{"a.example.com", "b.c.example.com", "api1.example.org", "other.example.net"} {
+		if _, err := a.Manager().GetCertificate(hello(name)); err == nil {
+			t.Fatalf("%s: certificate issued", name)
+		}
+	}
+	if n := atomic.LoadInt32(requests); n != 0 {
+		t.Fatalf("disallowed hosts sent %d requests to acme server", n)
+	}
+
+	// 精确注册 和 Hosts 向 ACME 服务器 申请
+	for _, name := range []string{"www.example.com", "static.example.net"} {
+		before := atomic.LoadInt32(requests)
+		if _, err := a.Manager().GetCertificate(hello(name)); err == nil {
+			t.Fatalf("%s: fake acme issued certificate", name)
+		}
+		if atomic.LoadInt32(requests) == before {
+			t.Fatalf("%s: acme server not contacted", name)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// 按 host 选择 中间件链 优先级 精确 > 通配 (后缀长的优先) > 正则 (注册顺序) > 默认
+	// 精确 example.com  通配 *.example.com 匹配所有子域名 不匹配 example.com  正则 ~^api\d+\.example\.com$
+	// * 或 空 的中间件 加入所有 host 的链 同时作为默认链
+	// 运行中 可以 Register Unregister 只重新生成 修改的 host 的链 全局中间件 修改时 重新生成所有链
+	// 有状态的中间件 (限流 缓存 等) 在 其他 host 修改时 不会重新创建 读取无锁
+	HostRouter struct {
+		notFound http.Handler
+
+		mux      sync.Mutex
+		seq      int
+		globals  []hostHandler
+		hosts    map[string][]hostHandler
+		regexps  []string
+		chains   map[string]http.Handler
+		fallback http.Handler
+		table    atomic.Value
+	}
+
+	hostHandler struct {
+		HandlerOption
+		seq int
+	}
+
+	hostMatcher struct {
+		suffix  string
+		re      *regexp.Regexp
+		handler http.Handler
+	}
+
+	hostTable struct {
+		exact     map[string]http.Handler
+		wildcards []hostMatcher
+		regexps   []hostMatcher
+		fallback  http.Handler
+	}
+)
+
+var ErrInvalidHost = errors.New("http invalid host pattern")
+
+func NewHostRouter(notFound http.Handler) (router *HostRouter) {
+	router = &HostRouter{
+		notFound: notFound,
+		hosts:    map[string][]hostHandler{},
+		chains:   map[string]http.Handler{},
+	}
+	router.fallback = router.chain(nil)
+	router.rebuild()
+	return
+}
+
+// 向 host 的链 添加中间件 按 index 排序 相同 index 按添加顺序
+func (router *HostRouter) Register(host string, index int, handler HandlerFunc) (err error) {
+	return router.registerAll([]HandlerOption{{Index: index, Hosts: []string{host}, Handler: handler}})
+}
+
+// 批量添加 每个 host 的链 只生成一次 Hosts 为空 时 为全局
+func (router *HostRouter) registerAll(options []HandlerOption) (err error) {
+	type pending struct {
+		host    string
+		handler hostHandler
+	}
+	var pendings []pending
+	for _, option := range options {
+		hosts := option.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{"*"}
+		}
+		for _, host := range hosts {
+			if host, err = normalizeHost(host); err != nil {
+				return
+			}
+			pendings = append(pendings, pending{host: host, handler: hostHandler{HandlerOption: HandlerOption{Index: option.Index, Hosts: []string{host}, Handler: option.Handler}}})
+		}
+	}
+
+	router.mux.Lock()
+	defer router.mux.Unlock()
+	changed := map[string]bool{}
+	for _, p := range pendings {
+		router.seq++
+		p.handler.seq = router.seq
+		if p.host == "" {
+			router.globals = append(router.globals, p.handler)
+		} else {
+			if _, ok := router.hosts[p.host]; !ok && strings.HasPrefix(p.host, "~") {
+				router.regexps = append(router.regexps, p.host)
+			}
+			router.hosts[p.host] = append(router.hosts[p.host], p.handler)
+		}
+		changed[p.host] = true
+	}
+	router.rechain(changed)
+	router.rebuild()
+	return
+}
+
+// 删除 host 的整个链 之后 按优先级 匹配其他规则
+func (router *HostRouter) Unregister(host string) (err error) {
+	if host, err = normalizeHost(host); err != nil {
+		return
+	}
+	router.mux.Lock()
+	defer router.mux.Unlock()
+	if host == "" {
+		router.globals = nil
+	} else {
+		delete(router.hosts, host)
+		delete(router.chains, host)
+		for i, v := range router.regexps {
+			if v == host {
+				router.regexps = append(router.regexps[:i:i], router.regexps[i+1:]...)
+				break
+			}
+		}
+	}
+	router.rechain(map[string]bool{host: true})
+	router.rebuild()
+	return
+}
+
+// 已注册的 host 规则
+func (router *HostRouter) Hosts() (hosts []string) {
+	router.mux.Lock()
+	defer router.mux.Unlock()
+	for host := range router.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return
+}
+
+// 返回 host 匹配的链
+func (router *HostRouter) Match(host string) http.Handler {
+	handler, _ := router.lookup(host)
+	return handler
+}
+
+// host 是否匹配 已注册的 精确 通配 或 正则 规则 不包含默认链
+func (router *HostRouter) Has(host string) bool {
+	_, ok := router.lookup(host)
+	return ok
+}
+
+// host 是否 精确注册 不匹配 通配 和 正则
+func (router *HostRouter) HasExact(host string) bool {
+	_, ok := router.table.Load().(*hostTable).exact[strings.ToLower(host)]
+	return ok
+}
+
+func (router *HostRouter) lookup(host string) (http.Handler, bool) {
+	table := router.table.Load().(*hostTable)
+	host = strings.ToLower(host)
+	if handler, ok := table.exact[host]; ok {
+		return handler, true
+	}
+	for _, m := range table.wildcards {
+		if strings.HasSuffix(host, m.suffix) && len(host) > len(m.suffix) {
+			return m.handler, true
+		}
+	}
+	for _, m := range table.regexps {
+		if m.re.MatchString(host) {
+			return m.handler, true
+		}
+	}
+	return table.fallback, false
+}
+
+func (router *HostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := Host(r, "")
+	r = r.WithContext(context.WithValue(r.Context(), "hostname", host))
+	router.Match(host).ServeHTTP(w, r)
+}
+
+// 持有 mux 时调用 重新生成 changed 中 host 的链 "" 为全局 修改时 重新生成所有链
+func (router *HostRouter) rechain(changed map[string]bool) {
+	if changed[""] {
+		router.fallback = router.chain(nil)
+		for host, handlers := range router.hosts {
+			router.chains[host] = router.chain(handlers)
+		}
+		return
+	}
+	for host := range changed {
+		if handlers, ok := router.hosts[host]; ok {
+			router.chains[host] = router.chain(handlers)
+		}
+	}
+}
+
+// 持有 mux 时调用 使用 已生成的链 不调用 HandlerFunc 规则 在 Register 时 已校验
+func (router *HostRouter) rebuild() {
+	table := &hostTable{
+		exact:    map[string]http.Handler{},
+		fallback: router.fallback,
+	}
+	for host, handler := range router.chains {
+		switch {
+		case strings.HasPrefix(host, "*."):
+			table.wildcards = append(table.wildcards, hostMatcher{suffix: host[1:], handler: handler})
+		case strings.HasPrefix(host, "~"):
+		default:
+			table.exact[host] = handler
+		}
+	}
+	sort.Slice(table.wildcards, func(i, j int) bool {
+		return len(table.wildcards[i].suffix) > len(table.wildcards[j].suffix)
+	})
+	for _, host := range router.regexps {
+		table.regexps = append(table.regexps, hostMatcher{
+			re:      regexp.MustCompile(host[1:]),
+			handler: router.chains[host],
+		})
+	}
+	router.table.Store(table)
+}
+
+// 全局 和 host 的中间件 排序后 倒序包装 最后面添加的 丢到最里面去
+func (router *HostRouter) chain(handlers []hostHandler) (handler http.Handler) {
+	all := append(append([]hostHandler{}, router.globals...), handlers...)
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Index != all[j].Index {
+			return all[i].Index < all[j].Index
+		}
+		return all[i].seq < all[j].seq
+	})
+	handler = router.notFound
+	for i := len(all) - 1; i >= 0; i-- {
+		handler = all[i].Handler(handler)
+	}
+	return
+}
+
+// * 和 空 返回 ""
+func normalizeHost(host string) (string, error) {
+	if host == "*" || host == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(host, "~") {
+		if _, err := regexp.Compile(host[1:]); err != nil {
+			return "", err
+		}
+		return host, nil
+	}
+	host = strings.ToLower(host)
+	if strings.Contains(host[1:], "*") || (strings.HasPrefix(host, "*") && !strings.HasPrefix(host, "*.")) {
+		return "", ErrInvalidHost
+	}
+	return host, nil
+}
@@ -6,8 +6,6 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"sort"
-	"strings"
 	"time"
 
 	"github.com/otamoe/go-library/http/certificate"
@@ -19,6 +17,9 @@ type (
 		*http.Server
 		StartTimeout time.Duration
 		Handlers     []HandlerOption
+		Routes       []RouteOption
+		Router       *HostRouter
+		ACME         *ACME
 	}
 
 	InOptions struct {
@@ -50,6 +51,12 @@ func NewServer(inOptions InOptions, lc fx.Lifecycle) (server *Server, err error)
 		}
 	}
 
+	if server.TLSConfig == nil && server.ACME != nil {
+		server.ACME.init(server)
+		server.TLSConfig = server.ACME.tlsConfig()
+		server.ACME.lifecycle(lc)
+	}
+
 	if server.TLSConfig == nil && (server.Addr == ":443" || server.Addr == ":8443") {
 		var cert *certificate.Certificate
 		if cert, err = certificate.CreateTLSCertificate("ecdsa", 384, "localhost", []string{"localhost"}, false, nil); err != nil {
@@ -66,72 +73,17 @@ func NewServer(inOptions InOptions, lc fx.Lifecycle) (server *Server, err error)
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 	})
 
-	// 控制器 排序
-	sort.Slice(server.Handlers, func(i, j int) bool {
-		return server.Handlers[i].Index < server.Handlers[j].Index
-	})
-
-	// 中间件 倒序 最后面添加的 丢到最里面去
-	handlers := map[string][]HandlerFunc{}
-
-	for _, oh := range server.Handlers {
-
-		if len(oh.Hosts) == 0 {
-			oh.Hosts = []string{"*"}
-		}
-		for _, host := range oh.Hosts {
-			host = strings.ToLower(host)
-			if host == "*" || host == "" {
-				// 全局控制器
-				for k, _ := range handlers {
-					handlers[k] = append(handlers[k], oh.Handler)
-				}
-
-				// 没 * 写入到 *
-				if _, ok := handlers[""]; !ok {
-					handlers[""] = []HandlerFunc{}
-					handlers[""] = append(handlers[""], oh.Handler)
-				}
-			} else {
-				// 局部控制器
-				if _, ok := handlers[host]; !ok {
-					handlers[host] = []HandlerFunc{}
-
-					// 添加 * host 的
-					if _, ok := handlers[""]; ok {
-						handlers[host] = append(handlers[host], handlers[""]...)
-					}
-
-				}
-				handlers[host] = append(handlers[host], oh.Handler)
-			}
-		}
+	// 中间件 按 host 注册 运行中 可以通过 server.Router 修改
+	server.Router = NewHostRouter(notFoundHandler)
+	var routes []HandlerOption
+	if routes, err = routeHandlers(server.Routes); err != nil {
+		return
 	}
-
-	httpHandlers := map[string]http.Handler{}
-	for host, value := range handlers {
-		// 中间件 倒序 最后面添加的 丢到最里面去
-		var httpHandler http.Handler
-		httpHandler = notFoundHandler
-
-		for i := len(value) - 1; i >= 0; i-- {
-			httpHandler = value[i](httpHandler)
-		}
-
-		httpHandlers[host] = httpHandler
+	server.Handlers = append(server.Handlers, routes...)
+	if err = server.Router.registerAll(server.Handlers); err != nil {
+		return
 	}
-
-	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		host := Host(r, "")
-		r = r.WithContext(context.WithValue(r.Context(), "hostname", host))
-		if handler, ok := httpHandlers[host]; ok {
-			handler.ServeHTTP(w, r)
-		} else if handler, ok := httpHandlers[""]; ok {
-			handler.ServeHTTP(w, r)
-		} else {
-			notFoundHandler.ServeHTTP(w, r)
-		}
-	})
+	server.Handler = server.Router
 
 	lc.Append(fx.Hook{
 		OnStart: func(c context.Context) (err error) {
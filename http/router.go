@@ -0,0 +1,276 @@
+package libhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// 路径路由 作为中间件使用 未匹配的路径 交给 next
+	// 静态 /users/me  参数 /users/:id  通配 /files/*path 只能在最后 匹配剩余部分
+	// 优先级 静态 > 参数 > 通配 方法不匹配时 继续尝试 参数 和 通配  路径匹配 方法都不匹配 返回 405
+	Router struct {
+		tree     *routeTree
+		prefix   string
+		handlers []HandlerFunc
+	}
+
+	RouteOption struct {
+		Index   int
+		Hosts   []string
+		Method  string
+		Pattern string
+		Handler http.Handler
+	}
+
+	routeTree struct {
+		mux  sync.RWMutex
+		root *routeNode
+	}
+
+	routeNode struct {
+		static   map[string]*routeNode
+		param    *routeNode
+		wildcard *routeNode
+		name     string
+		// 方法 "" 匹配所有方法
+		methods map[string]http.Handler
+	}
+
+	paramsKey struct{}
+)
+
+var ErrRouteConflict = errors.New("http route conflict")
+var ErrInvalidRoute = errors.New("http invalid route pattern")
+
+func NewRouter() *Router {
+	return &Router{tree: &routeTree{root: &routeNode{}}}
+}
+
+// 路径参数 不存在时 返回 ""
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+func Params(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params
+}
+
+// 路由组 共用路由表 路径加上 prefix 路由 依次经过 上级 和 本组 的中间件
+func (router *Router) Group(prefix string, handlers ...HandlerFunc) *Router {
+	return &Router{
+		tree:     router.tree,
+		prefix:   router.prefix + strings.TrimSuffix(prefix, "/"),
+		handlers: append(append([]HandlerFunc{}, router.handlers...), handlers...),
+	}
+}
+
+// 运行中 也可以添加 组内的 "/" 为 组的路径
+func (router *Router) Handle(method string, pattern string, handler http.Handler) (err error) {
+	if router.prefix != "" && pattern == "/" {
+		pattern = ""
+	}
+	pattern = router.prefix + pattern
+	if !strings.HasPrefix(pattern, "/") {
+		return ErrInvalidRoute
+	}
+	for i := len(router.handlers) - 1; i >= 0; i-- {
+		handler = router.handlers[i](handler)
+	}
+	method = strings.ToUpper(method)
+
+	router.tree.mux.Lock()
+	defer router.tree.mux.Unlock()
+	node := router.tree.root
+	segments := strings.Split(pattern[1:], "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			if len(segment) == 1 {
+				return ErrInvalidRoute
+			}
+			if node.param == nil {
+				node.param = &routeNode{name: segment[1:]}
+			} else if node.param.name != segment[1:] {
+				return ErrRouteConflict
+			}
+			node = node.param
+		case strings.HasPrefix(segment, "*"):
+			if len(segment) == 1 || i != len(segments)-1 {
+				return ErrInvalidRoute
+			}
+			if node.wildcard == nil {
+				node.wildcard = &routeNode{name: segment[1:]}
+			} else if node.wildcard.name != segment[1:] {
+				return ErrRouteConflict
+			}
+			node = node.wildcard
+		default:
+			if node.static == nil {
+				node.static = map[string]*routeNode{}
+			}
+			child, ok := node.static[segment]
+			if !ok {
+				child = &routeNode{}
+				node.static[segment] = child
+			}
+			node = child
+		}
+	}
+	if node.methods == nil {
+		node.methods = map[string]http.Handler{}
+	}
+	if _, ok := node.methods[method]; ok {
+		return ErrRouteConflict
+	}
+	node.methods[method] = handler
+	return
+}
+
+func (router *Router) HandleFunc(method string, pattern string, handler func(w http.ResponseWriter, r *http.Request)) error {
+	return router.Handle(method, pattern, http.HandlerFunc(handler))
+}
+
+// 作为 HandlerFunc 使用 例如 WithHandler(hosts, index, router.Handler)
+func (router *Router) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.tree.mux.RLock()
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		node, params := router.tree.root.match(segments, func(node *routeNode) bool {
+			return node.handler(r.Method) != nil
+		})
+		var handler http.Handler
+		var allow []string
+		if node != nil {
+			handler = node.handler(r.Method)
+		} else {
+			// 所有 路径匹配的节点 的方法
+			methods := map[string]bool{}
+			router.tree.root.match(segments, func(node *routeNode) bool {
+				for method := range node.methods {
+					methods[method] = true
+				}
+				return false
+			})
+			if methods[http.MethodGet] {
+				methods[http.MethodHead] = true
+			}
+			for method := range methods {
+				allow = append(allow, method)
+			}
+		}
+		router.tree.mux.RUnlock()
+
+		switch {
+		case node == nil && len(allow) == 0:
+			next.ServeHTTP(w, r)
+			return
+		case handler == nil:
+			sort.Strings(allow)
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if len(params) != 0 {
+			// 合并 外层路由的参数
+			for k, v := range Params(r) {
+				if _, ok := params[k]; !ok {
+					params[k] = v
+				}
+			}
+			r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// 方法的处理器 HEAD 使用 GET "" 匹配所有方法
+func (node *routeNode) handler(method string) http.Handler {
+	handler, ok := node.methods[method]
+	if !ok && method == http.MethodHead {
+		handler, ok = node.methods[http.MethodGet]
+	}
+	if !ok {
+		handler = node.methods[""]
+	}
+	return handler
+}
+
+// 回溯匹配 按优先级 返回 第一个 accept 的节点
+func (node *routeNode) match(segments []string, accept func(node *routeNode) bool) (*routeNode, map[string]string) {
+	if len(segments) == 0 {
+		if len(node.methods) != 0 && accept(node) {
+			return node, nil
+		}
+		return nil, nil
+	}
+	segment := segments[0]
+	if child, ok := node.static[segment]; ok {
+		if n, p := child.match(segments[1:], accept); n != nil {
+			return n, p
+		}
+	}
+	if node.param != nil && segment != "" {
+		if n, p := node.param.match(segments[1:], accept); n != nil {
+			return n, withParam(p, node.param.name, segment)
+		}
+	}
+	if node.wildcard != nil && len(node.wildcard.methods) != 0 && accept(node.wildcard) {
+		return node.wildcard, withParam(nil, node.wildcard.name, strings.Join(segments, "/"))
+	}
+	return nil, nil
+}
+
+func withParam(params map[string]string, name string, value string) map[string]string {
+	if params == nil {
+		params = map[string]string{}
+	}
+	params[name] = value
+	return params
+}
+
+// 通过 httpOptions 注册路由 hosts 和 index 相同的路由 共用一个 Router
+func WithRoute(hosts []string, index int, method string, pattern string, handler http.Handler) func() (out OutOption) {
+	return func() (out OutOption) {
+		out.Option = func(server *Server) error {
+			server.Routes = append(server.Routes, RouteOption{Hosts: hosts, Index: index, Method: method, Pattern: pattern, Handler: handler})
+			return nil
+		}
+		return
+	}
+}
+
+// 按 hosts 和 index 分组 生成 Router 加入 Handlers
+func routeHandlers(routes []RouteOption) (handlers []HandlerOption, err error) {
+	routers := map[string]*Router{}
+	for _, route := range routes {
+		hosts := make([]string, len(route.Hosts))
+		for i, host := range route.Hosts {
+			hosts[i] = strings.ToLower(host)
+		}
+		sort.Strings(hosts)
+		key := strings.Join(hosts, ",") + "|" + strconv.Itoa(route.Index)
+		router, ok := routers[key]
+		if !ok {
+			router = NewRouter()
+			routers[key] = router
+			handlers = append(handlers, HandlerOption{Index: route.Index, Hosts: route.Hosts, Handler: router.Handler})
+		}
+		if err = router.Handle(route.Method, route.Pattern, route.Handler); err != nil {
+			return
+		}
+	}
+	return
+}
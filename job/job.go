@@ -0,0 +1,373 @@
+package libjob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	libkv "github.com/otamoe/go-library/kv"
+	libutils "github.com/otamoe/go-library/utils"
+	libviper "github.com/otamoe/go-library/viper"
+	"github.com/spf13/viper"
+)
+
+type (
+	State string
+
+	Job struct {
+		ID          string          `json:"id"`
+		Queue       string          `json:"queue"`
+		Payload     json.RawMessage `json:"payload,omitempty"`
+		State       State           `json:"state"`
+		Attempts    int             `json:"attempts"`
+		MaxAttempts int             `json:"maxAttempts"`
+		// 处理中 定时写入 内容由 处理函数 决定
+		Progress json.RawMessage `json:"progress,omitempty"`
+		Result   json.RawMessage `json:"result,omitempty"`
+		Error    string          `json:"error,omitempty"`
+
+		CreatedAt   time.Time `json:"createdAt"`
+		UpdatedAt   time.Time `json:"updatedAt"`
+		AvailableAt time.Time `json:"availableAt"`
+		FinishedAt  time.Time `json:"finishedAt,omitempty"`
+
+		LeaseOwner     string    `json:"leaseOwner,omitempty"`
+		LeaseExpiresAt time.Time `json:"leaseExpiresAt,omitempty"`
+		// 运行中 被取消 由 持有租约 的 worker 在下次心跳时 停止
+		CancelRequested bool `json:"cancelRequested,omitempty"`
+	}
+
+	// 任务队列 存储需要支持事务 badger txnkv memory
+	// 记录 {prefix}j/{id}  待运行 {prefix}q/{queue}/{availableAt}/{id}  租约 {prefix}l/{expiresAt}/{id}
+	// 死信 {prefix}d/{queue}/{id}  已结束 {prefix}f/{finishedAt}/{id}
+	Queue struct {
+		kv     libkv.KV
+		prefix string
+		owner  string
+
+		LeaseTTL    time.Duration
+		MaxAttempts int
+		Backoff     time.Duration
+		MaxBackoff  time.Duration
+	}
+
+	EnqueueOptions struct {
+		ID          string
+		MaxAttempts int
+		Delay       time.Duration
+	}
+
+	EnqueueOption func(enqueueOptions *EnqueueOptions)
+)
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateDead      State = "dead"
+	StateCanceled  State = "canceled"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+var ErrJobExists = errors.New("job already exists")
+var ErrJobFinished = errors.New("job already finished")
+var ErrNoJob = errors.New("job queue is empty")
+var ErrInvalidQueue = errors.New("job queue name is empty or contains /")
+
+func init() {
+	libviper.SetDefault("job.prefix", "job/", "job kv key prefix")
+	libviper.SetDefault("job.leaseTTL", time.Second*30, "job lease ttl, expired leases are requeued")
+	libviper.SetDefault("job.maxAttempts", 3, "job default max attempts before dead letter")
+	libviper.SetDefault("job.backoff", time.Second*10, "job retry backoff")
+	libviper.SetDefault("job.maxBackoff", time.Minute*10, "job retry max backoff")
+	libviper.SetDefault("job.pollInterval", time.Second, "job worker poll interval when queue is empty")
+}
+
+func (state State) Finished() bool {
+	return state == StateSucceeded || state == StateDead || state == StateCanceled
+}
+
+func WithJobID(id string) EnqueueOption {
+	return func(enqueueOptions *EnqueueOptions) {
+		enqueueOptions.ID = id
+	}
+}
+
+func WithMaxAttempts(maxAttempts int) EnqueueOption {
+	return func(enqueueOptions *EnqueueOptions) {
+		enqueueOptions.MaxAttempts = maxAttempts
+	}
+}
+
+func WithDelay(delay time.Duration) EnqueueOption {
+	return func(enqueueOptions *EnqueueOptions) {
+		enqueueOptions.Delay = delay
+	}
+}
+
+func NewQueue(kv libkv.KV) *Queue {
+	hostname, _ := os.Hostname()
+	return &Queue{
+		kv:          kv,
+		prefix:      viper.GetString("job.prefix"),
+		owner:       fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), libutils.RandByte(8, libutils.RandAlphaNumber)),
+		LeaseTTL:    viper.GetDuration("job.leaseTTL"),
+		MaxAttempts: viper.GetInt("job.maxAttempts"),
+		Backoff:     viper.GetDuration("job.backoff"),
+		MaxBackoff:  viper.GetDuration("job.maxBackoff"),
+	}
+}
+
+func (queue *Queue) Owner() string {
+	return queue.owner
+}
+
+func (queue *Queue) Enqueue(ctx context.Context, name string, payload []byte, opts ...EnqueueOption) (job *Job, err error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, ErrInvalidQueue
+	}
+	enqueueOptions := &EnqueueOptions{MaxAttempts: queue.MaxAttempts}
+	for _, o := range opts {
+		o(enqueueOptions)
+	}
+	if enqueueOptions.ID == "" {
+		enqueueOptions.ID = newID()
+	}
+	now := time.Now()
+	job = &Job{
+		ID:          enqueueOptions.ID,
+		Queue:       name,
+		Payload:     payload,
+		State:       StatePending,
+		MaxAttempts: enqueueOptions.MaxAttempts,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		AvailableAt: now.Add(enqueueOptions.Delay),
+	}
+	err = queue.txn(ctx, func(txn libkv.Txn) (err error) {
+		if _, err = queue.get(ctx, txn, job.ID); err == nil {
+			return ErrJobExists
+		} else if err != ErrJobNotFound {
+			return
+		}
+		if err = queue.put(ctx, txn, job); err != nil {
+			return
+		}
+		return txn.Put(ctx, queue.readyKey(job), []byte{})
+	})
+	if err != nil {
+		job = nil
+	}
+	return
+}
+
+func (queue *Queue) Get(ctx context.Context, id string) (job *Job, err error) {
+	var b []byte
+	if b, err = queue.kv.Get(ctx, queue.jobKey(id)); err != nil {
+		if err == libkv.ErrNotFound {
+			err = ErrJobNotFound
+		}
+		return
+	}
+	job = &Job{}
+	err = json.Unmarshal(b, job)
+	return
+}
+
+// 待运行 直接取消 运行中 标记后 由 worker 停止 已结束 返回 ErrJobFinished
+func (queue *Queue) Cancel(ctx context.Context, id string) (err error) {
+	return queue.txn(ctx, func(txn libkv.Txn) (err error) {
+		var job *Job
+		if job, err = queue.get(ctx, txn, id); err != nil {
+			return
+		}
+		switch job.State {
+		case StatePending:
+			if err = txn.Delete(ctx, queue.readyKey(job)); err != nil {
+				return
+			}
+			return queue.finish(ctx, txn, job, StateCanceled)
+		case StateRunning:
+			job.CancelRequested = true
+			job.UpdatedAt = time.Now()
+			return queue.put(ctx, txn, job)
+		default:
+			return ErrJobFinished
+		}
+	})
+}
+
+// 死信队列 中的任务
+func (queue *Queue) DeadLetters(ctx context.Context, name string, limit int) (jobs []*Job, err error) {
+	var pairs []libkv.Pair
+	if pairs, err = queue.kv.Scan(ctx, libkv.ScanOptions{Prefix: []byte(queue.prefix + "d/" + name + "/"), Limit: limit}); err != nil {
+		return
+	}
+	for _, pair := range pairs {
+		var job *Job
+		if job, err = queue.Get(ctx, string(pair.Key[len(queue.prefix)+len("d/")+len(name)+1:])); err != nil {
+			if err == ErrJobNotFound {
+				err = nil
+				continue
+			}
+			return
+		}
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// 死信 重新加入队列 尝试次数 清零
+func (queue *Queue) Requeue(ctx context.Context, id string) (err error) {
+	return queue.txn(ctx, func(txn libkv.Txn) (err error) {
+		var job *Job
+		if job, err = queue.get(ctx, txn, id); err != nil {
+			return
+		}
+		if job.State != StateDead {
+			return fmt.Errorf("job %s is %s", id, job.State)
+		}
+		if err = txn.Delete(ctx, queue.deadKey(job)); err != nil {
+			return
+		}
+		if err = txn.Delete(ctx, queue.finishedKey(job)); err != nil {
+			return
+		}
+		now := time.Now()
+		job.State = StatePending
+		job.Attempts = 0
+		job.Error = ""
+		job.FinishedAt = time.Time{}
+		job.UpdatedAt = now
+		job.AvailableAt = now
+		if err = queue.put(ctx, txn, job); err != nil {
+			return
+		}
+		return txn.Put(ctx, queue.readyKey(job), []byte{})
+	})
+}
+
+// 删除 before 之前 结束的任务 死信 除外
+func (queue *Queue) Purge(ctx context.Context, before time.Time) (n int, err error) {
+	for {
+		var pairs []libkv.Pair
+		if pairs, err = queue.kv.Scan(ctx, libkv.ScanOptions{
+			Prefix: []byte(queue.prefix + "f/"),
+			End:    []byte(queue.prefix + "f/" + timeKey(before)),
+			Limit:  256,
+		}); err != nil || len(pairs) == 0 {
+			return
+		}
+		for _, pair := range pairs {
+			id := string(pair.Key[len(queue.prefix)+len("f/")+len(timeKey(before))+1:])
+			if err = queue.txn(ctx, func(txn libkv.Txn) (err error) {
+				if err = txn.Delete(ctx, pair.Key); err != nil {
+					return
+				}
+				var job *Job
+				if job, err = queue.get(ctx, txn, id); err == ErrJobNotFound {
+					return nil
+				} else if err != nil || job.State == StateDead {
+					return
+				}
+				return txn.Delete(ctx, queue.jobKey(id))
+			}); err != nil {
+				return
+			}
+			n++
+		}
+	}
+}
+
+// 冲突时 重试
+func (queue *Queue) txn(ctx context.Context, fn func(txn libkv.Txn) error) (err error) {
+	for i := 0; i < 10; i++ {
+		if err = queue.kv.Txn(ctx, fn); err != libkv.ErrConflict {
+			return
+		}
+		select {
+		case <-time.After(time.Millisecond * time.Duration(10+libutils.RandInt(40))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return
+}
+
+func (queue *Queue) get(ctx context.Context, txn libkv.Txn, id string) (job *Job, err error) {
+	var b []byte
+	if b, err = txn.Get(ctx, queue.jobKey(id)); err != nil {
+		if err == libkv.ErrNotFound {
+			err = ErrJobNotFound
+		}
+		return
+	}
+	job = &Job{}
+	err = json.Unmarshal(b, job)
+	return
+}
+
+func (queue *Queue) put(ctx context.Context, txn libkv.Txn, job *Job) (err error) {
+	var b []byte
+	if b, err = json.Marshal(job); err != nil {
+		return
+	}
+	return txn.Put(ctx, queue.jobKey(job.ID), b)
+}
+
+// 设置为 结束状态 死信 额外写入 死信索引
+func (queue *Queue) finish(ctx context.Context, txn libkv.Txn, job *Job, state State) (err error) {
+	now := time.Now()
+	job.State = state
+	job.FinishedAt = now
+	job.UpdatedAt = now
+	job.LeaseOwner = ""
+	job.LeaseExpiresAt = time.Time{}
+	if state == StateDead {
+		if err = txn.Put(ctx, queue.deadKey(job), []byte{}); err != nil {
+			return
+		}
+	}
+	if err = txn.Put(ctx, queue.finishedKey(job), []byte{}); err != nil {
+		return
+	}
+	return queue.put(ctx, txn, job)
+}
+
+func (queue *Queue) jobKey(id string) []byte {
+	return []byte(queue.prefix + "j/" + id)
+}
+
+func (queue *Queue) readyKey(job *Job) []byte {
+	return []byte(queue.prefix + "q/" + job.Queue + "/" + timeKey(job.AvailableAt) + "/" + job.ID)
+}
+
+func (queue *Queue) leaseKey(job *Job) []byte {
+	return []byte(queue.prefix + "l/" + timeKey(job.LeaseExpiresAt) + "/" + job.ID)
+}
+
+func (queue *Queue) deadKey(job *Job) []byte {
+	return []byte(queue.prefix + "d/" + job.Queue + "/" + job.ID)
+}
+
+func (queue *Queue) finishedKey(job *Job) []byte {
+	return []byte(queue.prefix + "f/" + timeKey(job.FinishedAt) + "/" + job.ID)
+}
+
+// 固定长度 按字节排序 即按时间排序
+func timeKey(t time.Time) string {
+	n := t.UnixNano()
+	if n < 0 {
+		n = 0
+	}
+	return fmt.Sprintf("%016x", n)
+}
+
+// 按时间排序
+func newID() string {
+	return timeKey(time.Now()) + string(libutils.RandByte(8, libutils.RandAlphaLowerNumber))
+}
@@ -0,0 +1,304 @@
+package libjob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	libkv "github.com/otamoe/go-library/kv"
+)
+
+type (
+	// 持有任务的租约 需要在 LeaseTTL 内 Heartbeat 或 Progress 否则 任务被重新分配
+	Lease struct {
+		queue *Queue
+
+		mux sync.Mutex
+		job *Job
+	}
+
+	permanentError struct {
+		err error
+	}
+)
+
+var ErrLeaseLost = errors.New("job lease lost")
+var ErrCanceled = errors.New("job canceled")
+
+// 不重试 直接进入死信
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// 获取 一个可运行的任务 没有时 返回 ErrNoJob
+func (queue *Queue) Lease(ctx context.Context, name string) (lease *Lease, err error) {
+	var pairs []libkv.Pair
+	if pairs, err = queue.kv.Scan(ctx, libkv.ScanOptions{
+		Prefix: []byte(queue.prefix + "q/" + name + "/"),
+		End:    []byte(queue.prefix + "q/" + name + "/" + timeKey(time.Now().Add(time.Nanosecond))),
+		Limit:  16,
+	}); err != nil {
+		return
+	}
+	for _, pair := range pairs {
+		id := string(pair.Key[len(queue.prefix)+len("q/")+len(name)+1+16+1:])
+		var job *Job
+		err = queue.kv.Txn(ctx, func(txn libkv.Txn) (err error) {
+			// 已被其他 worker 获取
+			if _, err = txn.Get(ctx, pair.Key); err != nil {
+				return
+			}
+			if job, err = queue.get(ctx, txn, id); err != nil {
+				return
+			}
+			if err = txn.Delete(ctx, pair.Key); err != nil {
+				return
+			}
+			if job.State != StatePending {
+				// 失效的索引 删除即可
+				job = nil
+				return
+			}
+			now := time.Now()
+			job.State = StateRunning
+			job.Attempts++
+			job.UpdatedAt = now
+			job.LeaseOwner = queue.owner
+			job.LeaseExpiresAt = now.Add(queue.LeaseTTL)
+			if err = txn.Put(ctx, queue.leaseKey(job), []byte{}); err != nil {
+				return
+			}
+			return queue.put(ctx, txn, job)
+		})
+		switch {
+		case err == nil && job != nil:
+			return &Lease{queue: queue, job: job}, nil
+		case err == nil, err == libkv.ErrConflict, err == libkv.ErrNotFound, err == ErrJobNotFound:
+			continue
+		default:
+			return
+		}
+	}
+	return nil, ErrNoJob
+}
+
+// 租约过期的任务 重新加入队列 尝试次数用完时 进入死信 返回处理的数量
+func (queue *Queue) Recover(ctx context.Context) (n int, err error) {
+	var pairs []libkv.Pair
+	if pairs, err = queue.kv.Scan(ctx, libkv.ScanOptions{
+		Prefix: []byte(queue.prefix + "l/"),
+		End:    []byte(queue.prefix + "l/" + timeKey(time.Now())),
+		Limit:  256,
+	}); err != nil {
+		return
+	}
+	for _, pair := range pairs {
+		id := string(pair.Key[len(queue.prefix)+len("l/")+16+1:])
+		if err = queue.txn(ctx, func(txn libkv.Txn) (err error) {
+			if _, err = txn.Get(ctx, pair.Key); err != nil {
+				return
+			}
+			if err = txn.Delete(ctx, pair.Key); err != nil {
+				return
+			}
+			var job *Job
+			if job, err = queue.get(ctx, txn, id); err != nil {
+				return
+			}
+			if job.State != StateRunning || !job.LeaseExpiresAt.Before(time.Now()) {
+				return
+			}
+			if job.CancelRequested {
+				return queue.finish(ctx, txn, job, StateCanceled)
+			}
+			return queue.retry(ctx, txn, job, ErrLeaseLost.Error(), false)
+		}); err == libkv.ErrNotFound || err == ErrJobNotFound {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// 重新加入队列 或 进入死信 已请求取消时 直接取消
+func (queue *Queue) retry(ctx context.Context, txn libkv.Txn, job *Job, message string, permanent bool) (err error) {
+	job.Error = message
+	if job.CancelRequested {
+		return queue.finish(ctx, txn, job, StateCanceled)
+	}
+	if permanent || job.Attempts >= job.MaxAttempts {
+		return queue.finish(ctx, txn, job, StateDead)
+	}
+	backoff := queue.Backoff
+	for i := 1; i < job.Attempts && backoff > 0; i++ {
+		if backoff *= 2; queue.MaxBackoff > 0 && backoff > queue.MaxBackoff {
+			backoff = queue.MaxBackoff
+			break
+		}
+	}
+	now := time.Now()
+	job.State = StatePending
+	job.UpdatedAt = now
+	job.AvailableAt = now.Add(backoff)
+	job.LeaseOwner = ""
+	job.LeaseExpiresAt = time.Time{}
+	if err = queue.put(ctx, txn, job); err != nil {
+		return
+	}
+	return txn.Put(ctx, queue.readyKey(job), []byte{})
+}
+
+// 当前任务 的副本
+func (lease *Lease) Job() Job {
+	lease.mux.Lock()
+	defer lease.mux.Unlock()
+	return *lease.job
+}
+
+// 续期 被取消时 返回 ErrCanceled
+func (lease *Lease) Heartbeat(ctx context.Context) (err error) {
+	return lease.update(ctx, func(job *Job) error {
+		if job.CancelRequested {
+			return ErrCanceled
+		}
+		return nil
+	})
+}
+
+// 写入进度 并续期 v 为 json 编码的内容
+func (lease *Lease) Progress(ctx context.Context, v interface{}) (err error) {
+	var b []byte
+	if b, err = json.Marshal(v); err != nil {
+		return
+	}
+	return lease.update(ctx, func(job *Job) error {
+		job.Progress = b
+		if job.CancelRequested {
+			return ErrCanceled
+		}
+		return nil
+	})
+}
+
+// 成功
+func (lease *Lease) Complete(ctx context.Context, result []byte) (err error) {
+	return lease.end(ctx, func(txn libkv.Txn, job *Job) error {
+		job.Result = result
+		job.Error = ""
+		return lease.queue.finish(ctx, txn, job, StateSucceeded)
+	})
+}
+
+// 失败 按 退避 重试 Permanent 错误 或 尝试次数用完时 进入死信 已请求取消时 为取消
+func (lease *Lease) Fail(ctx context.Context, cause error) (err error) {
+	var permanent *permanentError
+	return lease.end(ctx, func(txn libkv.Txn, job *Job) error {
+		return lease.queue.retry(ctx, txn, job, cause.Error(), errors.As(cause, &permanent))
+	})
+}
+
+// 确认取消
+func (lease *Lease) Cancel(ctx context.Context) (err error) {
+	return lease.end(ctx, func(txn libkv.Txn, job *Job) error {
+		return lease.queue.finish(ctx, txn, job, StateCanceled)
+	})
+}
+
+// 放弃租约 立即重新加入队列 不计入尝试次数 用于 进程停止
+func (lease *Lease) Release(ctx context.Context) (err error) {
+	return lease.end(ctx, func(txn libkv.Txn, job *Job) (err error) {
+		if job.CancelRequested {
+			return lease.queue.finish(ctx, txn, job, StateCanceled)
+		}
+		now := time.Now()
+		job.Attempts--
+		job.State = StatePending
+		job.UpdatedAt = now
+		job.AvailableAt = now
+		job.LeaseOwner = ""
+		job.LeaseExpiresAt = time.Time{}
+		if err = lease.queue.put(ctx, txn, job); err != nil {
+			return
+		}
+		return txn.Put(ctx, lease.queue.readyKey(job), []byte{})
+	})
+}
+
+// 确认 仍持有租约 修改后 续期
+func (lease *Lease) update(ctx context.Context, fn func(job *Job) error) (err error) {
+	var job *Job
+	var fnErr error
+	if err = lease.queue.txn(ctx, func(txn libkv.Txn) (err error) {
+		if job, err = lease.current(ctx, txn); err != nil {
+			return
+		}
+		if err = txn.Delete(ctx, lease.queue.leaseKey(job)); err != nil {
+			return
+		}
+		fnErr = fn(job)
+		now := time.Now()
+		job.UpdatedAt = now
+		job.LeaseExpiresAt = now.Add(lease.queue.LeaseTTL)
+		if err = txn.Put(ctx, lease.queue.leaseKey(job), []byte{}); err != nil {
+			return
+		}
+		return lease.queue.put(ctx, txn, job)
+	}); err != nil {
+		return
+	}
+	lease.mux.Lock()
+	lease.job = job
+	lease.mux.Unlock()
+	return fnErr
+}
+
+// 结束租约
+func (lease *Lease) end(ctx context.Context, fn func(txn libkv.Txn, job *Job) error) (err error) {
+	var job *Job
+	if err = lease.queue.txn(ctx, func(txn libkv.Txn) (err error) {
+		if job, err = lease.current(ctx, txn); err != nil {
+			return
+		}
+		if err = txn.Delete(ctx, lease.queue.leaseKey(job)); err != nil {
+			return
+		}
+		return fn(txn, job)
+	}); err != nil {
+		return
+	}
+	lease.mux.Lock()
+	lease.job = job
+	lease.mux.Unlock()
+	return
+}
+
+func (lease *Lease) current(ctx context.Context, txn libkv.Txn) (job *Job, err error) {
+	lease.mux.Lock()
+	id, attempts := lease.job.ID, lease.job.Attempts
+	lease.mux.Unlock()
+	if job, err = lease.queue.get(ctx, txn, id); err == ErrJobNotFound {
+		return nil, ErrLeaseLost
+	} else if err != nil {
+		return
+	}
+	if job.State != StateRunning || job.LeaseOwner != lease.queue.owner || job.Attempts != attempts {
+		return nil, ErrLeaseLost
+	}
+	return
+}
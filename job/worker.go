@@ -0,0 +1,224 @@
+package libjob
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	libkv "github.com/otamoe/go-library/kv"
+	liblogger "github.com/otamoe/go-library/logger"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type (
+	// 返回的 result 写入 Job.Result  ctx 在 取消 租约丢失 或 停止 时结束
+	Handler func(ctx context.Context, lease *Lease) (result []byte, err error)
+
+	Worker struct {
+		Queue       string
+		Concurrency int
+		Handler     Handler
+	}
+
+	InWorkers struct {
+		fx.In
+		Workers []Worker `group:"jobWorkers"`
+	}
+
+	OutWorker struct {
+		fx.Out
+		Worker Worker `group:"jobWorkers"`
+	}
+
+	Workers struct {
+		queue        *Queue
+		workers      []Worker
+		PollInterval time.Duration
+
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+)
+
+// 需要 libkv.New() 存储需要支持事务
+func New() fx.Option {
+	return fx.Options(
+		fx.Provide(NewQueue),
+		fx.Provide(NewWorkers),
+		fx.Invoke(func(*Workers) {}),
+	)
+}
+
+func RegisterWorker(worker Worker) func() (out OutWorker) {
+	return func() (out OutWorker) {
+		out.Worker = worker
+		return
+	}
+}
+
+// 启动时 运行所有 worker 停止时 取消运行中的任务 并放弃租约 任务立即被其他进程获取
+func NewWorkers(lc fx.Lifecycle, queue *Queue, inWorkers InWorkers) (workers *Workers) {
+	workers = &Workers{
+		queue:        queue,
+		workers:      inWorkers.Workers,
+		PollInterval: viper.GetDuration("job.pollInterval"),
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			workers.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return workers.Stop(ctx)
+		},
+	})
+	return
+}
+
+func (workers *Workers) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	workers.cancel = cancel
+	for _, worker := range workers.workers {
+		concurrency := worker.Concurrency
+		if concurrency <= 0 {
+			concurrency = 1
+		}
+		for i := 0; i < concurrency; i++ {
+			workers.wg.Add(1)
+			go workers.run(ctx, worker)
+		}
+	}
+	workers.wg.Add(1)
+	go workers.recover(ctx)
+}
+
+func (workers *Workers) Stop(ctx context.Context) error {
+	if workers.cancel == nil {
+		return nil
+	}
+	workers.cancel()
+	done := make(chan struct{})
+	go func() {
+		workers.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (workers *Workers) run(ctx context.Context, worker Worker) {
+	defer workers.wg.Done()
+	logger := liblogger.Get("job." + worker.Queue)
+	for ctx.Err() == nil {
+		lease, err := workers.queue.Lease(ctx, worker.Queue)
+		if err != nil {
+			if err != ErrNoJob && ctx.Err() == nil {
+				logger.Error("lease", zap.Error(err))
+			}
+			select {
+			case <-time.After(workers.PollInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		workers.handle(ctx, logger, worker, lease)
+	}
+}
+
+func (workers *Workers) handle(ctx context.Context, logger *zap.Logger, worker Worker, lease *Lease) {
+	job := lease.Job()
+	fields := []zap.Field{zap.String("id", job.ID), zap.Int("attempt", job.Attempts)}
+	logger.Info("start", fields...)
+
+	handlerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 心跳 被取消 或 租约丢失时 结束 handler
+	var heartbeatErr error
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		interval := workers.queue.LeaseTTL / 3
+		if interval <= 0 {
+			interval = time.Second
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-handlerCtx.Done():
+				return
+			}
+			if err := lease.Heartbeat(handlerCtx); err == ErrCanceled || err == ErrLeaseLost {
+				heartbeatErr = err
+				cancel()
+				return
+			} else if err != nil && handlerCtx.Err() == nil {
+				logger.Warn("heartbeat", append(fields, zap.Error(err))...)
+			}
+		}
+	}()
+
+	start := time.Now()
+	result, err := worker.Handler(handlerCtx, lease)
+	cancel()
+	<-heartbeatDone
+	fields = append(fields, zap.Duration("latency", time.Since(start)))
+
+	// 停止 取消 时 handler 的 ctx 已结束 使用新的 ctx 写入结果
+	endCtx, endCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer endCancel()
+	var endErr error
+	switch {
+	case heartbeatErr == ErrLeaseLost || errors.Is(err, ErrLeaseLost):
+		logger.Warn("lease lost", fields...)
+		return
+	case heartbeatErr == ErrCanceled || errors.Is(err, ErrCanceled):
+		logger.Info("canceled", fields...)
+		endErr = lease.Cancel(endCtx)
+	case err == nil:
+		logger.Info("complete", fields...)
+		endErr = lease.Complete(endCtx, result)
+	case ctx.Err() != nil:
+		logger.Info("released", append(fields, zap.Error(err))...)
+		endErr = lease.Release(endCtx)
+	default:
+		logger.Error("failed", append(fields, zap.Error(err))...)
+		endErr = lease.Fail(endCtx, err)
+	}
+	if endErr != nil {
+		logger.Error("end", append(fields, zap.Error(endErr))...)
+	}
+}
+
+// 定时 回收 过期租约
+func (workers *Workers) recover(ctx context.Context) {
+	defer workers.wg.Done()
+	logger := liblogger.Get("job")
+	interval := workers.queue.LeaseTTL / 2
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if n, err := workers.queue.Recover(ctx); err != nil && ctx.Err() == nil && err != libkv.ErrConflict {
+			logger.Error("recover", zap.Error(err))
+		} else if n != 0 {
+			logger.Info("recover", zap.Int("jobs", n))
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package libkv
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+type (
+	Badger struct {
+		db *badger.DB
+	}
+
+	badgerTxn struct {
+		txn *badger.Txn
+	}
+)
+
+func NewBadger(db *badger.DB) KV {
+	return &Badger{db: db}
+}
+
+func (b *Badger) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	err = b.db.View(func(txn *badger.Txn) (err error) {
+		value, err = (&badgerTxn{txn: txn}).Get(ctx, key)
+		return
+	})
+	return
+}
+
+func (b *Badger) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return b.Txn(ctx, func(txn Txn) error {
+		return txn.Put(ctx, key, value)
+	})
+}
+
+func (b *Badger) Delete(ctx context.Context, key []byte) (err error) {
+	return b.Txn(ctx, func(txn Txn) error {
+		return txn.Delete(ctx, key)
+	})
+}
+
+func (b *Badger) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	err = b.db.View(func(txn *badger.Txn) (err error) {
+		pairs, err = (&badgerTxn{txn: txn}).Scan(ctx, options)
+		return
+	})
+	return
+}
+
+func (b *Badger) BatchGet(ctx context.Context, keys [][]byte) (values map[string][]byte, err error) {
+	values = map[string][]byte{}
+	err = b.db.View(func(txn *badger.Txn) (err error) {
+		for _, key := range keys {
+			var value []byte
+			if value, err = (&badgerTxn{txn: txn}).Get(ctx, key); err == ErrNotFound {
+				err = nil
+				continue
+			} else if err != nil {
+				return
+			}
+			values[string(key)] = value
+		}
+		return
+	})
+	return
+}
+
+func (b *Badger) Batch(ctx context.Context, ops []Op) (err error) {
+	return b.Txn(ctx, func(txn Txn) (err error) {
+		for _, op := range ops {
+			if op.Delete {
+				err = txn.Delete(ctx, op.Key)
+			} else {
+				err = txn.Put(ctx, op.Key, op.Value)
+			}
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+func (b *Badger) Txn(ctx context.Context, fn func(txn Txn) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if err = b.db.Update(func(txn *badger.Txn) error {
+		return fn(&badgerTxn{txn: txn})
+	}); err == badger.ErrConflict {
+		err = ErrConflict
+	}
+	return
+}
+
+func (txn *badgerTxn) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	var item *badger.Item
+	if item, err = txn.txn.Get(key); err == badger.ErrKeyNotFound {
+		err = ErrNotFound
+		return
+	} else if err != nil {
+		return
+	}
+	return item.ValueCopy(nil)
+}
+
+func (txn *badgerTxn) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return txn.txn.Set(key, value)
+}
+
+func (txn *badgerTxn) Delete(ctx context.Context, key []byte) (err error) {
+	return txn.txn.Delete(key)
+}
+
+func (txn *badgerTxn) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	lower, upper := options.bounds()
+	limit := options.limit()
+
+	iteratorOptions := badger.DefaultIteratorOptions
+	iteratorOptions.Reverse = options.Reverse
+	// 反向时 Seek 的位置不带前缀 Valid 会直接返回 false
+	if !options.Reverse {
+		iteratorOptions.Prefix = options.Prefix
+	}
+	if limit < iteratorOptions.PrefetchSize {
+		iteratorOptions.PrefetchSize = limit
+	}
+	iterator := txn.txn.NewIterator(iteratorOptions)
+	defer iterator.Close()
+
+	if options.Reverse {
+		if upper == nil {
+			iterator.Rewind()
+		} else {
+			iterator.Seek(upper)
+		}
+	} else {
+		iterator.Seek(lower)
+	}
+	for ; iterator.Valid() && len(pairs) < limit; iterator.Next() {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		item := iterator.Item()
+		key := item.Key()
+		if options.Reverse {
+			if upper != nil && bytes.Compare(key, upper) >= 0 {
+				continue
+			}
+			if bytes.Compare(key, lower) < 0 {
+				break
+			}
+		} else if upper != nil && bytes.Compare(key, upper) >= 0 {
+			break
+		}
+		pair := Pair{Key: item.KeyCopy(nil)}
+		if pair.Value, err = item.ValueCopy(nil); err != nil {
+			return
+		}
+		pairs = append(pairs, pair)
+	}
+	return
+}
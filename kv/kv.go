@@ -0,0 +1,126 @@
+package libkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	libviper "github.com/otamoe/go-library/viper"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+)
+
+type (
+	// 与存储无关的 kv 接口
+	KV interface {
+		Get(ctx context.Context, key []byte) (value []byte, err error)
+		Put(ctx context.Context, key []byte, value []byte) (err error)
+		Delete(ctx context.Context, key []byte) (err error)
+		Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error)
+
+		// 不存在的 key 不在结果中
+		BatchGet(ctx context.Context, keys [][]byte) (values map[string][]byte, err error)
+
+		// 支持事务的存储 原子执行
+		Batch(ctx context.Context, ops []Op) (err error)
+
+		// fn 返回错误时回滚 冲突时返回 ErrConflict 不支持时返回 ErrNotSupported
+		Txn(ctx context.Context, fn func(txn Txn) error) (err error)
+	}
+
+	Txn interface {
+		Get(ctx context.Context, key []byte) (value []byte, err error)
+		Put(ctx context.Context, key []byte, value []byte) (err error)
+		Delete(ctx context.Context, key []byte) (err error)
+		Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error)
+	}
+
+	// Start 包含 End 不包含 Limit 默认 1000
+	ScanOptions struct {
+		Prefix  []byte
+		Start   []byte
+		End     []byte
+		Limit   int
+		Reverse bool
+	}
+
+	Pair struct {
+		Key   []byte
+		Value []byte
+	}
+
+	Op struct {
+		Key    []byte
+		Value  []byte
+		Delete bool
+	}
+)
+
+const (
+	BackendBadger = "badger"
+	BackendRawkv  = "rawkv"
+	BackendTxnkv  = "txnkv"
+	BackendMemory = "memory"
+)
+
+var ErrNotFound = errors.New("kv key not found")
+var ErrConflict = errors.New("kv transaction conflict")
+var ErrNotSupported = errors.New("kv operation not supported")
+var ErrUnknownBackend = errors.New("kv unknown backend")
+
+func init() {
+	libviper.SetDefault("kv.backend", BackendBadger, "kv backend  badger, rawkv, txnkv, memory")
+}
+
+// 根据 kv.backend 选择存储 需要在 libviper.Parse 之后调用
+// badger rawkv txnkv 需要同时引入 libbadger.New() 或 libtikv.New()
+// rawkv txnkv 使用 libtikv.RawkvClient libtikv.TxnkvClient 的配置
+func New() fx.Option {
+	switch backend := viper.GetString("kv.backend"); backend {
+	case BackendBadger:
+		return fx.Provide(NewBadger)
+	case BackendRawkv:
+		return fx.Provide(NewRawkvClient)
+	case BackendTxnkv:
+		return fx.Provide(NewTxnkvClient)
+	case BackendMemory:
+		return fx.Provide(func() KV {
+			return NewMemory()
+		})
+	default:
+		return fx.Error(fmt.Errorf("%w: %s", ErrUnknownBackend, backend))
+	}
+}
+
+func (options ScanOptions) limit() int {
+	if options.Limit <= 0 {
+		return 1000
+	}
+	return options.Limit
+}
+
+// 合并 Prefix Start End 为 [lower, upper) upper 为 nil 时不限制
+func (options ScanOptions) bounds() (lower []byte, upper []byte) {
+	lower = options.Prefix
+	if bytes.Compare(options.Start, lower) > 0 {
+		lower = options.Start
+	}
+	upper = PrefixEnd(options.Prefix)
+	if options.End != nil && (upper == nil || bytes.Compare(options.End, upper) < 0) {
+		upper = options.End
+	}
+	return
+}
+
+// 大于所有 prefix 开头的 key 的最小 key 没有时返回 nil
+func PrefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte{}, prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package libkv
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type (
+	// 进程内实现 用于测试 事务串行执行
+	Memory struct {
+		mux  sync.RWMutex
+		data map[string][]byte
+	}
+
+	memoryTxn struct {
+		data map[string][]byte
+	}
+)
+
+func NewMemory() *Memory {
+	return &Memory{data: map[string][]byte{}}
+}
+
+func (m *Memory) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return (&memoryTxn{data: m.data}).Get(ctx, key)
+}
+
+func (m *Memory) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return m.Txn(ctx, func(txn Txn) error {
+		return txn.Put(ctx, key, value)
+	})
+}
+
+func (m *Memory) Delete(ctx context.Context, key []byte) (err error) {
+	return m.Txn(ctx, func(txn Txn) error {
+		return txn.Delete(ctx, key)
+	})
+}
+
+func (m *Memory) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return (&memoryTxn{data: m.data}).Scan(ctx, options)
+}
+
+func (m *Memory) BatchGet(ctx context.Context, keys [][]byte) (values map[string][]byte, err error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	values = map[string][]byte{}
+	for _, key := range keys {
+		if value, ok := m.data[string(key)]; ok {
+			values[string(key)] = append([]byte{}, value...)
+		}
+	}
+	return
+}
+
+func (m *Memory) Batch(ctx context.Context, ops []Op) (err error) {
+	return m.Txn(ctx, func(txn Txn) (err error) {
+		for _, op := range ops {
+			if op.Delete {
+				err = txn.Delete(ctx, op.Key)
+			} else {
+				err = txn.Put(ctx, op.Key, op.Value)
+			}
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// 在副本上执行 成功后替换
+func (m *Memory) Txn(ctx context.Context, fn func(txn Txn) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	txn := &memoryTxn{data: make(map[string][]byte, len(m.data))}
+	for key, value := range m.data {
+		txn.data[key] = value
+	}
+	if err = fn(txn); err != nil {
+		return
+	}
+	m.data = txn.data
+	return
+}
+
+func (txn *memoryTxn) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	var ok bool
+	if value, ok = txn.data[string(key)]; !ok {
+		err = ErrNotFound
+		return
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (txn *memoryTxn) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	txn.data[string(key)] = append([]byte{}, value...)
+	return
+}
+
+func (txn *memoryTxn) Delete(ctx context.Context, key []byte) (err error) {
+	delete(txn.data, string(key))
+	return
+}
+
+func (txn *memoryTxn) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	lower, upper := options.bounds()
+	var keys []string
+	for key := range txn.data {
+		if key < string(lower) || (upper != nil && key >= string(upper)) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if options.Reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	for _, key := range keys {
+		if len(pairs) == options.limit() {
+			break
+		}
+		pairs = append(pairs, Pair{Key: []byte(key), Value: append([]byte{}, txn.data[key]...)})
+	}
+	return
+}
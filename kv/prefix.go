@@ -0,0 +1,118 @@
+package libkv
+
+import (
+	"context"
+)
+
+type (
+	prefixKV struct {
+		kv     KV
+		prefix []byte
+	}
+
+	prefixTxn struct {
+		txn    Txn
+		prefix []byte
+	}
+)
+
+// 所有 key 加上前缀 返回的 key 去掉前缀
+func WithPrefix(kv KV, prefix []byte) KV {
+	if len(prefix) == 0 {
+		return kv
+	}
+	return &prefixKV{kv: kv, prefix: append([]byte{}, prefix...)}
+}
+
+func (p *prefixKV) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	return p.kv.Get(ctx, addPrefix(p.prefix, key))
+}
+
+func (p *prefixKV) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return p.kv.Put(ctx, addPrefix(p.prefix, key), value)
+}
+
+func (p *prefixKV) Delete(ctx context.Context, key []byte) (err error) {
+	return p.kv.Delete(ctx, addPrefix(p.prefix, key))
+}
+
+func (p *prefixKV) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	if pairs, err = p.kv.Scan(ctx, prefixScanOptions(p.prefix, options)); err != nil {
+		return
+	}
+	return trimPairs(p.prefix, pairs), nil
+}
+
+func (p *prefixKV) BatchGet(ctx context.Context, keys [][]byte) (values map[string][]byte, err error) {
+	prefixed := make([][]byte, len(keys))
+	for i, key := range keys {
+		prefixed[i] = addPrefix(p.prefix, key)
+	}
+	var res map[string][]byte
+	if res, err = p.kv.BatchGet(ctx, prefixed); err != nil {
+		return
+	}
+	values = make(map[string][]byte, len(res))
+	for key, value := range res {
+		values[key[len(p.prefix):]] = value
+	}
+	return
+}
+
+func (p *prefixKV) Batch(ctx context.Context, ops []Op) (err error) {
+	prefixed := make([]Op, len(ops))
+	for i, op := range ops {
+		op.Key = addPrefix(p.prefix, op.Key)
+		prefixed[i] = op
+	}
+	return p.kv.Batch(ctx, prefixed)
+}
+
+func (p *prefixKV) Txn(ctx context.Context, fn func(txn Txn) error) (err error) {
+	return p.kv.Txn(ctx, func(txn Txn) error {
+		return fn(&prefixTxn{txn: txn, prefix: p.prefix})
+	})
+}
+
+func (p *prefixTxn) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	return p.txn.Get(ctx, addPrefix(p.prefix, key))
+}
+
+func (p *prefixTxn) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return p.txn.Put(ctx, addPrefix(p.prefix, key), value)
+}
+
+func (p *prefixTxn) Delete(ctx context.Context, key []byte) (err error) {
+	return p.txn.Delete(ctx, addPrefix(p.prefix, key))
+}
+
+func (p *prefixTxn) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	if pairs, err = p.txn.Scan(ctx, prefixScanOptions(p.prefix, options)); err != nil {
+		return
+	}
+	return trimPairs(p.prefix, pairs), nil
+}
+
+func prefixScanOptions(prefix []byte, options ScanOptions) ScanOptions {
+	options.Prefix = addPrefix(prefix, options.Prefix)
+	if options.Start != nil {
+		options.Start = addPrefix(prefix, options.Start)
+	}
+	if options.End != nil {
+		options.End = addPrefix(prefix, options.End)
+	}
+	return options
+}
+
+func trimPairs(prefix []byte, pairs []Pair) []Pair {
+	for i := range pairs {
+		pairs[i].Key = pairs[i].Key[len(prefix):]
+	}
+	return pairs
+}
+
+func addPrefix(prefix []byte, key []byte) []byte {
+	b := make([]byte, 0, len(prefix)+len(key))
+	b = append(b, prefix...)
+	return append(b, key...)
+}
@@ -0,0 +1,105 @@
+package libkv
+
+import (
+	"context"
+
+	libtikv "github.com/otamoe/go-library/tikv"
+	"github.com/tikv/client-go/v2/rawkv"
+)
+
+type (
+	// 不支持事务 Batch 不是原子的
+	Rawkv struct {
+		client *rawkv.Client
+	}
+)
+
+func NewRawkv(client *rawkv.Client) KV {
+	return &Rawkv{client: client}
+}
+
+// 使用 客户端配置的 前缀
+func NewRawkvClient(client *libtikv.RawkvClient) KV {
+	return WithPrefix(NewRawkv(client.Client()), client.Config.KeyPrefix)
+}
+
+func (r *Rawkv) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	if value, err = r.client.Get(ctx, key); err == nil && value == nil {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (r *Rawkv) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return r.client.Put(ctx, key, value)
+}
+
+func (r *Rawkv) Delete(ctx context.Context, key []byte) (err error) {
+	return r.client.Delete(ctx, key)
+}
+
+// 反向扫描 必须有上限
+func (r *Rawkv) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	lower, upper := options.bounds()
+	limit := options.limit()
+	if limit > rawkv.MaxRawKVScanLimit {
+		limit = rawkv.MaxRawKVScanLimit
+	}
+	var keys, values [][]byte
+	if options.Reverse {
+		if upper == nil {
+			return nil, ErrNotSupported
+		}
+		keys, values, err = r.client.ReverseScan(ctx, upper, lower, limit)
+	} else {
+		keys, values, err = r.client.Scan(ctx, lower, upper, limit)
+	}
+	if err != nil {
+		return
+	}
+	pairs = make([]Pair, len(keys))
+	for i := range keys {
+		pairs[i] = Pair{Key: keys[i], Value: values[i]}
+	}
+	return
+}
+
+func (r *Rawkv) BatchGet(ctx context.Context, keys [][]byte) (values map[string][]byte, err error) {
+	var list [][]byte
+	if list, err = r.client.BatchGet(ctx, keys); err != nil {
+		return
+	}
+	values = map[string][]byte{}
+	for i, value := range list {
+		if value != nil {
+			values[string(keys[i])] = value
+		}
+	}
+	return
+}
+
+// 先写入 再删除 失败时 可能部分生效
+func (r *Rawkv) Batch(ctx context.Context, ops []Op) (err error) {
+	var putKeys, putValues, deleteKeys [][]byte
+	for _, op := range ops {
+		if op.Delete {
+			deleteKeys = append(deleteKeys, op.Key)
+		} else {
+			putKeys = append(putKeys, op.Key)
+			putValues = append(putValues, op.Value)
+		}
+	}
+	if len(putKeys) != 0 {
+		if err = r.client.BatchPut(ctx, putKeys, putValues); err != nil {
+			return
+		}
+	}
+	if len(deleteKeys) != 0 {
+		err = r.client.BatchDelete(ctx, deleteKeys)
+	}
+	return
+}
+
+func (r *Rawkv) Txn(ctx context.Context, fn func(txn Txn) error) (err error) {
+	return ErrNotSupported
+}
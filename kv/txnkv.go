@@ -0,0 +1,207 @@
+package libkv
+
+import (
+	"bytes"
+	"context"
+
+	libtikv "github.com/otamoe/go-library/tikv"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/txnkv/transaction"
+	"github.com/tikv/client-go/v2/txnkv/txnsnapshot"
+)
+
+type (
+	Txnkv struct {
+		client      *txnkv.Client
+		asyncCommit bool
+		onePC       bool
+	}
+
+	txnkvTxn struct {
+		txn *transaction.KVTxn
+	}
+
+	txnkvIterator interface {
+		Valid() bool
+		Key() []byte
+		Value() []byte
+		Next() error
+		Close()
+	}
+
+	// KVTxn 和 KVSnapshot 的共同部分
+	txnkvReader struct {
+		get         func(ctx context.Context, key []byte) ([]byte, error)
+		iter        func(k []byte, upperBound []byte) (txnkvIterator, error)
+		iterReverse func(k []byte) (txnkvIterator, error)
+	}
+)
+
+func NewTxnkv(client *txnkv.Client) KV {
+	return &Txnkv{client: client}
+}
+
+// 使用 客户端配置的 前缀 async commit 和 1PC
+func NewTxnkvClient(client *libtikv.TxnkvClient) KV {
+	return WithPrefix(&Txnkv{
+		client:      client.Client,
+		asyncCommit: client.Config.AsyncCommit,
+		onePC:       client.Config.OnePC,
+	}, client.Config.KeyPrefix)
+}
+
+func (t *Txnkv) snapshot(ctx context.Context) (reader txnkvReader, snapshot *txnsnapshot.KVSnapshot, err error) {
+	var ts uint64
+	if ts, err = t.client.GetTimestamp(ctx); err != nil {
+		return
+	}
+	snapshot = t.client.GetSnapshot(ts)
+	reader = txnkvReader{
+		get: snapshot.Get,
+		iter: func(k []byte, upperBound []byte) (txnkvIterator, error) {
+			return snapshot.Iter(k, upperBound)
+		},
+		iterReverse: func(k []byte) (txnkvIterator, error) {
+			return snapshot.IterReverse(k)
+		},
+	}
+	return
+}
+
+func (t *Txnkv) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	var reader txnkvReader
+	if reader, _, err = t.snapshot(ctx); err != nil {
+		return
+	}
+	return reader.Get(ctx, key)
+}
+
+func (t *Txnkv) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return t.Txn(ctx, func(txn Txn) error {
+		return txn.Put(ctx, key, value)
+	})
+}
+
+func (t *Txnkv) Delete(ctx context.Context, key []byte) (err error) {
+	return t.Txn(ctx, func(txn Txn) error {
+		return txn.Delete(ctx, key)
+	})
+}
+
+func (t *Txnkv) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	var reader txnkvReader
+	if reader, _, err = t.snapshot(ctx); err != nil {
+		return
+	}
+	return reader.Scan(ctx, options)
+}
+
+func (t *Txnkv) BatchGet(ctx context.Context, keys [][]byte) (values map[string][]byte, err error) {
+	var snapshot *txnsnapshot.KVSnapshot
+	if _, snapshot, err = t.snapshot(ctx); err != nil {
+		return
+	}
+	return snapshot.BatchGet(ctx, keys)
+}
+
+func (t *Txnkv) Batch(ctx context.Context, ops []Op) (err error) {
+	return t.Txn(ctx, func(txn Txn) (err error) {
+		for _, op := range ops {
+			if op.Delete {
+				err = txn.Delete(ctx, op.Key)
+			} else {
+				err = txn.Put(ctx, op.Key, op.Value)
+			}
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// 乐观事务 写冲突时返回 ErrConflict
+func (t *Txnkv) Txn(ctx context.Context, fn func(txn Txn) error) (err error) {
+	var txn *transaction.KVTxn
+	if txn, err = t.client.Begin(); err != nil {
+		return
+	}
+	txn.SetEnableAsyncCommit(t.asyncCommit)
+	txn.SetEnable1PC(t.onePC)
+	if err = fn(&txnkvTxn{txn: txn}); err != nil {
+		txn.Rollback()
+		return
+	}
+	if err = txn.Commit(ctx); tikverr.IsErrWriteConflict(err) {
+		err = ErrConflict
+	}
+	return
+}
+
+func (txn *txnkvTxn) reader() txnkvReader {
+	return txnkvReader{
+		get: txn.txn.Get,
+		iter: func(k []byte, upperBound []byte) (txnkvIterator, error) {
+			return txn.txn.Iter(k, upperBound)
+		},
+		iterReverse: func(k []byte) (txnkvIterator, error) {
+			return txn.txn.IterReverse(k)
+		},
+	}
+}
+
+func (txn *txnkvTxn) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	return txn.reader().Get(ctx, key)
+}
+
+func (txn *txnkvTxn) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return txn.txn.Set(key, value)
+}
+
+func (txn *txnkvTxn) Delete(ctx context.Context, key []byte) (err error) {
+	return txn.txn.Delete(key)
+}
+
+func (txn *txnkvTxn) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	return txn.reader().Scan(ctx, options)
+}
+
+func (reader txnkvReader) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	if value, err = reader.get(ctx, key); tikverr.IsErrNotFound(err) {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (reader txnkvReader) Scan(ctx context.Context, options ScanOptions) (pairs []Pair, err error) {
+	lower, upper := options.bounds()
+	limit := options.limit()
+	var iterator txnkvIterator
+	if options.Reverse {
+		iterator, err = reader.iterReverse(upper)
+	} else {
+		iterator, err = reader.iter(lower, upper)
+	}
+	if err != nil {
+		return
+	}
+	defer iterator.Close()
+	for iterator.Valid() && len(pairs) < limit {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		key := iterator.Key()
+		if options.Reverse && bytes.Compare(key, lower) < 0 {
+			break
+		}
+		pairs = append(pairs, Pair{
+			Key:   append([]byte{}, key...),
+			Value: append([]byte{}, iterator.Value()...),
+		})
+		if err = iterator.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,228 @@
+package libmetrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	libhttp "github.com/otamoe/go-library/http"
+	"go.uber.org/fx"
+)
+
+type (
+	// labels 为 key value 成对出现
+	Metrics interface {
+		Counter(name string, value float64, labels ...string)
+		Gauge(name string, value float64, labels ...string)
+		Histogram(name string, value float64, labels ...string)
+	}
+
+	Registry struct {
+		mux     sync.Mutex
+		metrics map[string]*metric
+	}
+
+	metric struct {
+		typ        string
+		help       string
+		values     map[string]float64
+		buckets    []float64
+		histograms map[string]*histogram
+	}
+
+	// counts 为 每个 bucket 的数量 不累加
+	histogram struct {
+		labels []string
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+const TypeCounter = "counter"
+const TypeGauge = "gauge"
+const TypeHistogram = "histogram"
+
+// 单位 秒 覆盖 毫秒级 到 小时级
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
+func New() fx.Option {
+	return fx.Options(
+		fx.Provide(NewRegistry),
+		fx.Provide(NewMetrics),
+	)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: map[string]*metric{},
+	}
+}
+
+func NewMetrics(registry *Registry) Metrics {
+	return registry
+}
+
+// 设置说明
+func (registry *Registry) Help(name string, help string) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	registry.get(name, "").help = help
+}
+
+func (registry *Registry) Counter(name string, value float64, labels ...string) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	m := registry.get(name, TypeCounter)
+	m.values[encodeLabels(labels)] += value
+}
+
+func (registry *Registry) Gauge(name string, value float64, labels ...string) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	m := registry.get(name, TypeGauge)
+	m.values[encodeLabels(labels)] = value
+}
+
+// 设置 histogram 的 bucket 上限 需要在第一次 Histogram 之前调用
+func (registry *Registry) Buckets(name string, buckets []float64) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	registry.get(name, "").buckets = buckets
+}
+
+func (registry *Registry) Histogram(name string, value float64, labels ...string) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	m := registry.get(name, TypeHistogram)
+	if m.buckets == nil {
+		m.buckets = DefaultBuckets
+	}
+	key := encodeLabels(labels)
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &histogram{labels: append([]string{}, labels...), counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+	}
+	if i := sort.SearchFloat64s(m.buckets, value); i < len(m.buckets) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+func (registry *Registry) get(name string, typ string) *metric {
+	m, ok := registry.metrics[name]
+	if !ok {
+		m = &metric{values: map[string]float64{}, histograms: map[string]*histogram{}}
+		registry.metrics[name] = m
+	}
+	if m.typ == "" {
+		m.typ = typ
+	}
+	return m
+}
+
+// 写入 text exposition 格式
+func (registry *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	buf := &bytes.Buffer{}
+
+	registry.mux.Lock()
+	names := make([]string, 0, len(registry.metrics))
+	for name := range registry.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := registry.metrics[name]
+		if len(m.values) == 0 && len(m.histograms) == 0 {
+			continue
+		}
+		if m.help != "" {
+			fmt.Fprintf(buf, "# HELP %s %s\n", name, strings.ReplaceAll(m.help, "\n", " "))
+		}
+		if m.typ != "" {
+			fmt.Fprintf(buf, "# TYPE %s %s\n", name, m.typ)
+		}
+		keys := make([]string, 0, len(m.values))
+		for key := range m.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(buf, "%s%s %s\n", name, key, formatFloat(m.values[key]))
+		}
+
+		keys = keys[:0]
+		for key := range m.histograms {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			h := m.histograms[key]
+			var cumulative uint64
+			for i, le := range m.buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", name, encodeLabels(append(h.labels, "le", formatFloat(le))), cumulative)
+			}
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", name, encodeLabels(append(h.labels, "le", "+Inf")), h.count)
+			fmt.Fprintf(buf, "%s_sum%s %s\n", name, key, formatFloat(h.sum))
+			fmt.Fprintf(buf, "%s_count%s %d\n", name, key, h.count)
+		}
+	}
+	registry.mux.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	registry.WriteTo(w)
+}
+
+func (registry *Registry) Handler(path string) libhttp.HandlerFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == path && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+				registry.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// 注册到 libhttp
+func WithHTTPHandler(hosts []string, index int, path string) func(registry *Registry) (out libhttp.OutOption) {
+	return func(registry *Registry) (out libhttp.OutOption) {
+		return libhttp.WithHandler(hosts, index, registry.Handler(path))()
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func encodeLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, labels[i]+`="`+value+`"`)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
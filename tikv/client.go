@@ -0,0 +1,73 @@
+package libtikv
+
+import (
+	"context"
+
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/txnkv/transaction"
+)
+
+type (
+	// 使用 tikv.rawkv.keyPrefix 所有 key 加上前缀 返回的 key 已去掉前缀
+	RawkvClient struct {
+		*Namespace
+		Config *ClientConfig
+	}
+
+	// 使用 tikv.txnkv.keyPrefix tikv.txnkv.asyncCommit tikv.txnkv.onePC
+	// 事务中的 key 需要通过 Key 加上前缀 读取到的 key 通过 TrimKey 去掉前缀
+	TxnkvClient struct {
+		*txnkv.Client
+		Config *ClientConfig
+	}
+)
+
+func NewRawkvClient(client *rawkv.Client) (rawkvClient *RawkvClient, err error) {
+	var clientConfig *ClientConfig
+	if clientConfig, err = NewClientConfig("rawkv"); err != nil {
+		return
+	}
+	return &RawkvClient{
+		Namespace: newNamespace(client, clientConfig.KeyPrefix),
+		Config:    clientConfig,
+	}, nil
+}
+
+func NewTxnkvClient(client *txnkv.Client) (txnkvClient *TxnkvClient, err error) {
+	var clientConfig *ClientConfig
+	if clientConfig, err = NewClientConfig("txnkv"); err != nil {
+		return
+	}
+	return &TxnkvClient{
+		Client: client,
+		Config: clientConfig,
+	}, nil
+}
+
+// 开始事务 使用配置的 async commit 和 1PC
+func (client *TxnkvClient) Begin() (txn *transaction.KVTxn, err error) {
+	if txn, err = client.Client.Begin(); err != nil {
+		return
+	}
+	txn.SetEnableAsyncCommit(client.Config.AsyncCommit)
+	txn.SetEnable1PC(client.Config.OnePC)
+	return
+}
+
+// 与 RunInTxn 相同 默认 使用配置的 async commit 和 1PC
+func (client *TxnkvClient) RunInTxn(ctx context.Context, fn func(txn *transaction.KVTxn) error, opts ...TxnOption) (err error) {
+	return RunInTxn(ctx, client.Client, fn, append([]TxnOption{WithCommitMode(client.Config.AsyncCommit, client.Config.OnePC)}, opts...)...)
+}
+
+// 加上前缀
+func (client *TxnkvClient) Key(key []byte) []byte {
+	b := make([]byte, 0, len(client.Config.KeyPrefix)+len(key))
+	b = append(b, client.Config.KeyPrefix...)
+	return append(b, key...)
+}
+
+// 去掉前缀
+func (client *TxnkvClient) TrimKey(key []byte) []byte {
+	return key[len(client.Config.KeyPrefix):]
+}
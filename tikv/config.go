@@ -0,0 +1,159 @@
+package libtikv
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/spf13/viper"
+	"github.com/tikv/client-go/v2/config"
+	pd "github.com/tikv/pd/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+type (
+	// 每个客户端 独立的配置 读取 tikv.{name}.*
+	ClientConfig struct {
+		Name             string
+		PDAddress        []string
+		APIVersion       kvrpcpb.APIVersion
+		KeepAliveTime    time.Duration
+		KeepAliveTimeout time.Duration
+		PDTimeout        time.Duration
+
+		// 通过 RawkvClient TxnkvClient 使用 *rawkv.Client *txnkv.Client 不处理
+		KeyPrefix   []byte
+		AsyncCommit bool
+		OnePC       bool
+	}
+)
+
+var (
+	globalMux        sync.Mutex
+	globalRefs       int
+	globalRestore    func()
+	globalSecurities []*config.Security
+)
+
+func NewClientConfig(name string) (clientConfig *ClientConfig, err error) {
+	prefix := "tikv." + name + "."
+	clientConfig = &ClientConfig{
+		Name:             name,
+		PDAddress:        viper.GetStringSlice(prefix + "pdAddress"),
+		KeepAliveTime:    viper.GetDuration(prefix + "grpc.keepAliveTime"),
+		KeepAliveTimeout: viper.GetDuration(prefix + "grpc.keepAliveTimeout"),
+		PDTimeout:        viper.GetDuration(prefix + "pdTimeout"),
+		KeyPrefix:        []byte(viper.GetString(prefix + "keyPrefix")),
+		AsyncCommit:      viper.GetBool(prefix + "asyncCommit"),
+		OnePC:            viper.GetBool(prefix + "onePC"),
+	}
+	if clientConfig.APIVersion, err = ParseAPIVersion(viper.GetString(prefix + "apiVersion")); err != nil {
+		return
+	}
+	return
+}
+
+// v1 v1ttl v2
+func ParseAPIVersion(s string) (apiVersion kvrpcpb.APIVersion, err error) {
+	switch strings.ToLower(s) {
+	case "", "v1":
+		apiVersion = kvrpcpb.APIVersion_V1
+	case "v1ttl":
+		apiVersion = kvrpcpb.APIVersion_V1TTL
+	case "v2":
+		apiVersion = kvrpcpb.APIVersion_V2
+	default:
+		err = fmt.Errorf("tikv unknown api version: %s", s)
+	}
+	return
+}
+
+func (clientConfig *ClientConfig) DialOptions() []grpc.DialOption {
+	if clientConfig.KeepAliveTime <= 0 {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    clientConfig.KeepAliveTime,
+			Timeout: clientConfig.KeepAliveTimeout,
+		}),
+	}
+}
+
+func (clientConfig *ClientConfig) PDOptions() (options []pd.ClientOption) {
+	options = append(options, pd.WithGRPCDialOptions(clientConfig.DialOptions()...))
+	if clientConfig.PDTimeout > 0 {
+		options = append(options, pd.WithCustomTimeoutOption(clientConfig.PDTimeout))
+	}
+	return
+}
+
+// client-go 只能通过全局配置 设置的部分 读取 tikv.global.*
+// 所有客户端共用 第一个客户端写入 最后一个客户端关闭时恢复
+// store 健康探测 只读取全局的 Security 使用 仍在运行的 第一个 设置了 tls 的客户端 所有客户端 需要连接同一个集群
+func acquireGlobalConfig(security config.Security) (release func()) {
+	globalMux.Lock()
+	defer globalMux.Unlock()
+	if globalRefs == 0 {
+		globalRestore = config.UpdateGlobal(func(conf *config.Config) {
+			if v := viper.GetUint("tikv.global.grpcConnectionCount"); v != 0 {
+				conf.TiKVClient.GrpcConnectionCount = v
+			}
+			if v := viper.GetDuration("tikv.global.grpcKeepAliveTime"); v > 0 {
+				conf.TiKVClient.GrpcKeepAliveTime = uint(v / time.Second)
+			}
+			if v := viper.GetDuration("tikv.global.grpcKeepAliveTimeout"); v > 0 {
+				conf.TiKVClient.GrpcKeepAliveTimeout = uint(v / time.Second)
+			}
+			if v := viper.GetDuration("tikv.global.commitTimeout"); v > 0 {
+				conf.TiKVClient.CommitTimeout = v.String()
+			}
+			conf.EnableForwarding = viper.GetBool("tikv.global.enableForwarding")
+			if v := viper.GetUint("tikv.global.txnLocalLatches"); v != 0 {
+				conf.TxnLocalLatches.Enabled = true
+				conf.TxnLocalLatches.Capacity = v
+			}
+		})
+	}
+	globalRefs++
+	s := &security
+	globalSecurities = append(globalSecurities, s)
+	updateGlobalSecurity()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			globalMux.Lock()
+			defer globalMux.Unlock()
+			for i, v := range globalSecurities {
+				if v == s {
+					globalSecurities = append(globalSecurities[:i:i], globalSecurities[i+1:]...)
+					break
+				}
+			}
+			if globalRefs--; globalRefs == 0 {
+				globalRestore()
+				globalRestore = nil
+				return
+			}
+			updateGlobalSecurity()
+		})
+	}
+}
+
+// 持有 globalMux 时调用
+func updateGlobalSecurity() {
+	var security config.Security
+	for _, v := range globalSecurities {
+		if v.ClusterSSLCA != "" {
+			security = *v
+			break
+		}
+	}
+	conf := *config.GetGlobalConfig()
+	conf.Security = security
+	config.StoreGlobalConfig(&conf)
+}
@@ -0,0 +1,44 @@
+package libtikv
+
+import (
+	"context"
+	"fmt"
+
+	libhealth "github.com/otamoe/go-library/health"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/client-go/v2/txnkv"
+	pd "github.com/tikv/pd/client"
+)
+
+func RawkvHealthChecker(client *rawkv.Client) (out libhealth.OutChecker) {
+	out.Checker = libhealth.Checker{
+		Name:  "tikv.rawkv",
+		Check: PDCheck(client.GetPDClient()),
+	}
+	return
+}
+
+func TxnkvHealthChecker(client *txnkv.Client) (out libhealth.OutChecker) {
+	out.Checker = libhealth.Checker{
+		Name:  "tikv.txnkv",
+		Check: PDCheck(client.GetPDClient()),
+	}
+	return
+}
+
+// PD 可以访问 且至少有一个 store 在线
+func PDCheck(pdClient pd.Client) libhealth.Check {
+	return func(ctx context.Context) (err error) {
+		var stores []*metapb.Store
+		if stores, err = pdClient.GetAllStores(ctx, pd.WithExcludeTombstone()); err != nil {
+			return
+		}
+		for _, store := range stores {
+			if store.GetState() == metapb.StoreState_Up {
+				return nil
+			}
+		}
+		return fmt.Errorf("tikv no store is up, %d stores", len(stores))
+	}
+}
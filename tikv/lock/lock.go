@@ -0,0 +1,327 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	libutils "github.com/otamoe/go-library/utils"
+	"go.uber.org/fx"
+)
+
+type (
+	// 事务 返回错误时回滚 冲突时 Txn 返回错误 由调用方重试
+	Store interface {
+		Txn(ctx context.Context, fn func(txn Txn) error) error
+	}
+
+	// Get 不存在时返回 nil, nil
+	Txn interface {
+		Get(ctx context.Context, key []byte) ([]byte, error)
+		Set(key []byte, value []byte) error
+		Delete(key []byte) error
+	}
+
+	Locker struct {
+		store  Store
+		prefix []byte
+		owner  string
+
+		// TTL 默认租期 Heartbeat 续期间隔 RetryInterval 抢锁重试间隔
+		TTL           time.Duration
+		Heartbeat     time.Duration
+		RetryInterval time.Duration
+
+		mux  sync.Mutex
+		held map[*Lock]struct{}
+	}
+
+	// Token 为 fencing token 每次获得锁都递增 写入下游时带上 拒绝更小的 token
+	Lock struct {
+		locker *Locker
+		Name   string
+		Token  uint64
+
+		mux       sync.Mutex
+		expiresAt time.Time
+		released  bool
+		cancel    context.CancelFunc
+		done      chan struct{}
+		doneOnce  sync.Once
+	}
+
+	// 释放后 保留记录 只清除 Owner 保证 Token 递增
+	record struct {
+		Owner     string `json:"owner"`
+		Token     uint64 `json:"token"`
+		ExpiresAt int64  `json:"expiresAt"`
+	}
+)
+
+var ErrLocked = errors.New("lock is held by another owner")
+var ErrNotHeld = errors.New("lock is not held")
+
+func New() fx.Option {
+	return fx.Options(
+		fx.Provide(NewTxnkvStore),
+		fx.Provide(NewLocker),
+	)
+}
+
+// 停止时 释放所有持有的锁
+func NewLocker(lc fx.Lifecycle, store Store) (locker *Locker) {
+	locker = NewLockerWithPrefix(store, "lock:")
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return locker.ReleaseAll(ctx)
+		},
+	})
+	return
+}
+
+func NewLockerWithPrefix(store Store, prefix string) *Locker {
+	hostname, _ := os.Hostname()
+	return &Locker{
+		store:         store,
+		prefix:        []byte(prefix),
+		owner:         fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), libutils.RandByte(8, libutils.RandAlphaNumber)),
+		TTL:           time.Second * 10,
+		RetryInterval: time.Millisecond * 100,
+		held:          map[*Lock]struct{}{},
+	}
+}
+
+func (locker *Locker) Owner() string {
+	return locker.owner
+}
+
+// 阻塞直到获得锁 ctx 的 deadline 为超时时间 ttl 为 0 时使用 locker.TTL
+func (locker *Locker) Acquire(ctx context.Context, name string, ttl time.Duration) (lock *Lock, err error) {
+	for {
+		if lock, err = locker.TryAcquire(ctx, name, ttl); err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		select {
+		case <-time.After(locker.RetryInterval + time.Duration(libutils.RandInt64(int64(locker.RetryInterval)+1))):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (locker *Locker) AcquireTimeout(ctx context.Context, name string, ttl time.Duration, timeout time.Duration) (lock *Lock, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return locker.Acquire(ctx, name, ttl)
+}
+
+// 尝试一次 被占用时返回 ErrLocked
+func (locker *Locker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (lock *Lock, err error) {
+	if ttl <= 0 {
+		ttl = locker.TTL
+	}
+	var rec record
+	if err = locker.store.Txn(ctx, func(txn Txn) (err error) {
+		var old record
+		var exists bool
+		if old, exists, err = locker.get(ctx, txn, name); err != nil {
+			return
+		}
+		now := time.Now()
+		if exists && old.Owner != "" && old.ExpiresAt > now.UnixNano() {
+			return ErrLocked
+		}
+		rec = record{
+			Owner:     locker.owner,
+			Token:     old.Token + 1,
+			ExpiresAt: now.Add(ttl).UnixNano(),
+		}
+		return locker.set(txn, name, rec)
+	}); err != nil {
+		return
+	}
+
+	heartbeat := locker.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = ttl / 3
+	}
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	lock = &Lock{
+		locker:    locker,
+		Name:      name,
+		Token:     rec.Token,
+		expiresAt: time.Unix(0, rec.ExpiresAt),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	locker.mux.Lock()
+	locker.held[lock] = struct{}{}
+	locker.mux.Unlock()
+	go lock.keepAlive(keepAliveCtx, ttl, heartbeat)
+	return
+}
+
+func (locker *Locker) ReleaseAll(ctx context.Context) (err error) {
+	locker.mux.Lock()
+	locks := make([]*Lock, 0, len(locker.held))
+	for lock := range locker.held {
+		locks = append(locks, lock)
+	}
+	locker.mux.Unlock()
+	for _, lock := range locks {
+		if e := lock.Release(ctx); e != nil && e != ErrNotHeld && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (locker *Locker) key(name string) []byte {
+	return append(append([]byte{}, locker.prefix...), name...)
+}
+
+func (locker *Locker) get(ctx context.Context, txn Txn, name string) (rec record, exists bool, err error) {
+	var b []byte
+	if b, err = txn.Get(ctx, locker.key(name)); err != nil || b == nil {
+		return
+	}
+	exists = true
+	err = json.Unmarshal(b, &rec)
+	return
+}
+
+func (locker *Locker) set(txn Txn, name string, rec record) (err error) {
+	var b []byte
+	if b, err = json.Marshal(rec); err != nil {
+		return
+	}
+	return txn.Set(locker.key(name), b)
+}
+
+func (locker *Locker) forget(lock *Lock) {
+	locker.mux.Lock()
+	delete(locker.held, lock)
+	locker.mux.Unlock()
+}
+
+// 租期内 且未释放
+func (lock *Lock) Held() bool {
+	lock.mux.Lock()
+	defer lock.mux.Unlock()
+	return !lock.released && time.Now().Before(lock.expiresAt)
+}
+
+func (lock *Lock) ExpiresAt() time.Time {
+	lock.mux.Lock()
+	defer lock.mux.Unlock()
+	return lock.expiresAt
+}
+
+// 锁 释放 或 丢失 时关闭
+func (lock *Lock) Done() <-chan struct{} {
+	return lock.done
+}
+
+// 续期 锁已被他人获得时 返回 ErrNotHeld
+func (lock *Lock) Refresh(ctx context.Context, ttl time.Duration) (err error) {
+	if ttl <= 0 {
+		ttl = lock.locker.TTL
+	}
+	lock.mux.Lock()
+	released := lock.released
+	lock.mux.Unlock()
+	if released {
+		return ErrNotHeld
+	}
+	var expiresAt time.Time
+	if err = lock.locker.store.Txn(ctx, func(txn Txn) (err error) {
+		var rec record
+		if rec, err = lock.current(ctx, txn); err != nil {
+			return
+		}
+		expiresAt = time.Now().Add(ttl)
+		rec.ExpiresAt = expiresAt.UnixNano()
+		return lock.locker.set(txn, lock.Name, rec)
+	}); err != nil {
+		if err == ErrNotHeld {
+			lock.lost()
+		}
+		return
+	}
+	lock.mux.Lock()
+	lock.expiresAt = expiresAt
+	lock.mux.Unlock()
+	return
+}
+
+func (lock *Lock) Release(ctx context.Context) (err error) {
+	lock.mux.Lock()
+	if lock.released {
+		lock.mux.Unlock()
+		return ErrNotHeld
+	}
+	lock.mux.Unlock()
+	lock.cancel()
+
+	err = lock.locker.store.Txn(ctx, func(txn Txn) (err error) {
+		var rec record
+		if rec, err = lock.current(ctx, txn); err != nil {
+			return
+		}
+		rec.Owner = ""
+		rec.ExpiresAt = 0
+		return lock.locker.set(txn, lock.Name, rec)
+	})
+	lock.lost()
+	return
+}
+
+// 读取记录 并确认仍是自己持有
+func (lock *Lock) current(ctx context.Context, txn Txn) (rec record, err error) {
+	var exists bool
+	if rec, exists, err = lock.locker.get(ctx, txn, lock.Name); err != nil {
+		return
+	}
+	if !exists || rec.Owner != lock.locker.owner || rec.Token != lock.Token || rec.ExpiresAt <= time.Now().UnixNano() {
+		err = ErrNotHeld
+	}
+	return
+}
+
+func (lock *Lock) lost() {
+	lock.mux.Lock()
+	lock.released = true
+	lock.mux.Unlock()
+	lock.cancel()
+	lock.locker.forget(lock)
+	lock.doneOnce.Do(func() {
+		close(lock.done)
+	})
+}
+
+// 定时续期 超过租期仍未续上 视为丢失
+func (lock *Lock) keepAlive(ctx context.Context, ttl time.Duration, heartbeat time.Duration) {
+	t := time.NewTicker(heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		refreshCtx, cancel := context.WithTimeout(ctx, heartbeat)
+		err := lock.Refresh(refreshCtx, ttl)
+		cancel()
+		if err == ErrNotHeld || (err != nil && !time.Now().Before(lock.ExpiresAt())) {
+			lock.lost()
+			return
+		}
+	}
+}
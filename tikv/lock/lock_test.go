@@ -0,0 +1,219 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLocker(store Store) *Locker {
+	locker := NewLockerWithPrefix(store, "lock:")
+	locker.RetryInterval = time.Millisecond * 5
+	return locker
+}
+
+func TestAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	locker := newTestLocker(NewMemoryStore())
+
+	lock, err := locker.TryAcquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lock.Held() {
+		t.Fatal("lock not held after acquire")
+	}
+	if err = lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if lock.Held() {
+		t.Fatal("lock held after release")
+	}
+	select {
+	case <-lock.Done():
+	default:
+		t.Fatal("done not closed after release")
+	}
+	if err = lock.Release(ctx); err != ErrNotHeld {
+		t.Fatalf("second release: %v", err)
+	}
+}
+
+func TestContention(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	a := newTestLocker(store)
+	b := newTestLocker(store)
+
+	lock, err := a.TryAcquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.TryAcquire(ctx, "a", time.Second); err != ErrLocked {
+		t.Fatalf("try acquire while held: %v", err)
+	}
+	if _, err = b.AcquireTimeout(ctx, "a", time.Second, time.Millisecond*30); err != context.DeadlineExceeded {
+		t.Fatalf("acquire timeout while held: %v", err)
+	}
+
+	acquired := make(chan *Lock, 1)
+	go func() {
+		lock, err := b.AcquireTimeout(ctx, "a", time.Second, time.Second*5)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- lock
+	}()
+	time.Sleep(time.Millisecond * 20)
+	if err = lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if lock = <-acquired; lock == nil || !lock.Held() {
+		t.Fatal("waiter did not acquire after release")
+	}
+	lock.Release(ctx)
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	a := newTestLocker(store)
+	// 不续期
+	a.Heartbeat = time.Hour
+	b := newTestLocker(store)
+
+	lock, err := a.TryAcquire(ctx, "a", time.Millisecond*30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if lock.Held() {
+		t.Fatal("lock held after ttl")
+	}
+	other, err := b.TryAcquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatalf("acquire after expiry: %v", err)
+	}
+	defer other.Release(ctx)
+	if err = lock.Refresh(ctx, time.Second); err != ErrNotHeld {
+		t.Fatalf("refresh after takeover: %v", err)
+	}
+	select {
+	case <-lock.Done():
+	default:
+		t.Fatal("done not closed after lock lost")
+	}
+}
+
+func TestRenew(t *testing.T) {
+	ctx := context.Background()
+	locker := newTestLocker(NewMemoryStore())
+	locker.Heartbeat = time.Hour
+
+	lock, err := locker.TryAcquire(ctx, "a", time.Millisecond*50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+	expiresAt := lock.ExpiresAt()
+	if err = lock.Refresh(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !lock.ExpiresAt().After(expiresAt) {
+		t.Fatal("refresh did not extend expiry")
+	}
+	time.Sleep(time.Millisecond * 80)
+	if !lock.Held() {
+		t.Fatal("lock not held after refresh")
+	}
+
+	// 自动续期
+	auto := newTestLocker(NewMemoryStore())
+	auto.Heartbeat = time.Millisecond * 10
+	lock, err = auto.TryAcquire(ctx, "a", time.Millisecond*40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+	time.Sleep(time.Millisecond * 120)
+	if !lock.Held() {
+		t.Fatal("keepalive did not renew lock")
+	}
+}
+
+func TestReleaseByNonOwner(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	a := newTestLocker(store)
+	a.Heartbeat = time.Hour
+	b := newTestLocker(store)
+
+	stale, err := a.TryAcquire(ctx, "a", time.Millisecond*20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 30)
+	lock, err := b.TryAcquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	if err = stale.Release(ctx); err != ErrNotHeld {
+		t.Fatalf("release by previous owner: %v", err)
+	}
+	if _, err = a.TryAcquire(ctx, "a", time.Second); err != ErrLocked {
+		t.Fatalf("lock released by non owner: %v", err)
+	}
+	if !lock.Held() {
+		t.Fatal("owner lost lock")
+	}
+}
+
+func TestFencingToken(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	a := newTestLocker(store)
+	a.Heartbeat = time.Hour
+	b := newTestLocker(store)
+	b.Heartbeat = time.Hour
+
+	var last uint64
+	check := func(lock *Lock) {
+		t.Helper()
+		if lock.Token <= last {
+			t.Fatalf("token %d not greater than %d", lock.Token, last)
+		}
+		last = lock.Token
+	}
+
+	lock, err := a.TryAcquire(ctx, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(lock)
+	lock.Release(ctx)
+
+	if lock, err = b.TryAcquire(ctx, "a", time.Millisecond*20); err != nil {
+		t.Fatal(err)
+	}
+	check(lock)
+
+	// 过期后 被接管 token 仍递增
+	time.Sleep(time.Millisecond * 30)
+	if lock, err = a.TryAcquire(ctx, "a", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	check(lock)
+	lock.Release(ctx)
+
+	// 不同的锁 token 独立
+	other, err := a.TryAcquire(ctx, "b", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Release(ctx)
+	if other.Token != 1 {
+		t.Fatalf("token of new lock: %d", other.Token)
+	}
+}
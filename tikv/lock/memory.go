@@ -0,0 +1,64 @@
+package lock
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// 进程内实现 用于测试 事务串行执行
+	MemoryStore struct {
+		mux  sync.Mutex
+		data map[string][]byte
+	}
+
+	memoryTxn struct {
+		store  *MemoryStore
+		writes map[string][]byte
+	}
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: map[string][]byte{}}
+}
+
+func (store *MemoryStore) Txn(ctx context.Context, fn func(txn Txn) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	txn := &memoryTxn{store: store, writes: map[string][]byte{}}
+	if err = fn(txn); err != nil {
+		return
+	}
+	for key, value := range txn.writes {
+		if value == nil {
+			delete(store.data, key)
+		} else {
+			store.data[key] = value
+		}
+	}
+	return
+}
+
+func (txn *memoryTxn) Get(ctx context.Context, key []byte) ([]byte, error) {
+	if value, ok := txn.writes[string(key)]; ok {
+		return value, nil
+	}
+	value, ok := txn.store.data[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (txn *memoryTxn) Set(key []byte, value []byte) error {
+	txn.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (txn *memoryTxn) Delete(key []byte) error {
+	txn.writes[string(key)] = nil
+	return nil
+}
@@ -0,0 +1,51 @@
+package lock
+
+import (
+	"context"
+
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/txnkv/transaction"
+)
+
+type (
+	TxnkvStore struct {
+		client *txnkv.Client
+	}
+
+	txnkvTxn struct {
+		txn *transaction.KVTxn
+	}
+)
+
+func NewTxnkvStore(client *txnkv.Client) Store {
+	return &TxnkvStore{client: client}
+}
+
+// 乐观事务 写冲突时 Commit 返回错误
+func (store *TxnkvStore) Txn(ctx context.Context, fn func(txn Txn) error) (err error) {
+	var txn *transaction.KVTxn
+	if txn, err = store.client.Begin(); err != nil {
+		return
+	}
+	if err = fn(&txnkvTxn{txn: txn}); err != nil {
+		txn.Rollback()
+		return
+	}
+	return txn.Commit(ctx)
+}
+
+func (txn *txnkvTxn) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	if value, err = txn.txn.Get(ctx, key); tikverr.IsErrNotFound(err) {
+		return nil, nil
+	}
+	return
+}
+
+func (txn *txnkvTxn) Set(key []byte, value []byte) error {
+	return txn.txn.Set(key, value)
+}
+
+func (txn *txnkvTxn) Delete(key []byte) error {
+	return txn.txn.Delete(key)
+}
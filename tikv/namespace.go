@@ -0,0 +1,299 @@
+package libtikv
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/tikv/client-go/v2/rawkv"
+	"go.uber.org/fx"
+)
+
+type (
+	// 多个服务共用一个集群 每个服务使用自己的 namespace
+	// 所有 key 加上 {namespace}/ 前缀 返回的 key 已去掉前缀
+	// TTL 需要 apiVersion v1ttl 或 v2 CAS 需要 tikv.rawkv.atomicForCAS
+	Namespace struct {
+		client *rawkv.Client
+		prefix []byte
+
+		// 每批最多 key 数量 和 字节数 超出时拆分
+		MaxBatchKeys  int
+		MaxBatchBytes int
+		// 迭代器 每页数量
+		PageSize int
+	}
+
+	Entry struct {
+		Key   []byte
+		Value []byte
+		// 0 不过期
+		TTL time.Duration
+	}
+
+	// 按前缀遍历 自动翻页
+	Iterator struct {
+		namespace *Namespace
+		start     []byte
+		end       []byte
+		pairs     []Entry
+		index     int
+		done      bool
+		err       error
+	}
+)
+
+var ErrInvalidNamespace = errors.New("tikv namespace is empty or contains /")
+var ErrCASConflict = errors.New("tikv compare and swap retries exhausted")
+
+// 提供 *Namespace
+func WithNamespace(namespace string) fx.Option {
+	return fx.Provide(func(client *rawkv.Client) (*Namespace, error) {
+		return NewNamespace(client, namespace)
+	})
+}
+
+func NewNamespace(client *rawkv.Client, namespace string) (*Namespace, error) {
+	if namespace == "" || strings.Contains(namespace, "/") {
+		return nil, ErrInvalidNamespace
+	}
+	return newNamespace(client, []byte(namespace+"/")), nil
+}
+
+func newNamespace(client *rawkv.Client, prefix []byte) *Namespace {
+	return &Namespace{
+		client:        client,
+		prefix:        prefix,
+		MaxBatchKeys:  1024,
+		MaxBatchBytes: 1024 * 1024 * 4,
+		PageSize:      256,
+	}
+}
+
+func (namespace *Namespace) Client() *rawkv.Client {
+	return namespace.client
+}
+
+// 不存在时 返回 nil
+func (namespace *Namespace) Get(ctx context.Context, key []byte) (value []byte, err error) {
+	return namespace.client.Get(ctx, namespace.key(key))
+}
+
+func (namespace *Namespace) Put(ctx context.Context, key []byte, value []byte) (err error) {
+	return namespace.client.Put(ctx, namespace.key(key), value)
+}
+
+func (namespace *Namespace) PutWithTTL(ctx context.Context, key []byte, value []byte, ttl time.Duration) (err error) {
+	return namespace.client.PutWithTTL(ctx, namespace.key(key), value, ttlSeconds(ttl))
+}
+
+// 不存在时 ok 为 false 不过期时 ttl 为 0
+func (namespace *Namespace) TTL(ctx context.Context, key []byte) (ttl time.Duration, ok bool, err error) {
+	var seconds *uint64
+	if seconds, err = namespace.client.GetKeyTTL(ctx, namespace.key(key)); err != nil || seconds == nil {
+		return
+	}
+	return time.Duration(*seconds) * time.Second, true, nil
+}
+
+func (namespace *Namespace) Delete(ctx context.Context, key []byte) (err error) {
+	return namespace.client.Delete(ctx, namespace.key(key))
+}
+
+// 删除 namespace 中 prefix 开头的所有 key
+func (namespace *Namespace) DeletePrefix(ctx context.Context, prefix []byte) (err error) {
+	start, end := namespace.bounds(prefix)
+	return namespace.client.DeleteRange(ctx, start, end)
+}
+
+// previous 为 nil 表示 key 不存在 返回 key 原来的值
+func (namespace *Namespace) CompareAndSwap(ctx context.Context, key []byte, previous []byte, value []byte) (actual []byte, swapped bool, err error) {
+	return namespace.client.CompareAndSwap(ctx, namespace.key(key), previous, value)
+}
+
+// 不存在时写入
+func (namespace *Namespace) PutIfAbsent(ctx context.Context, key []byte, value []byte) (actual []byte, ok bool, err error) {
+	return namespace.CompareAndSwap(ctx, key, nil, value)
+}
+
+// 读取 修改 CAS 写入 冲突时重试 fn 的参数为 nil 时 key 不存在
+func (namespace *Namespace) Update(ctx context.Context, key []byte, maxRetries int, fn func(old []byte) (value []byte, err error)) (value []byte, err error) {
+	var old []byte
+	if old, err = namespace.Get(ctx, key); err != nil {
+		return
+	}
+	for i := 0; ; i++ {
+		if value, err = fn(old); err != nil {
+			return
+		}
+		var swapped bool
+		if old, swapped, err = namespace.CompareAndSwap(ctx, key, old, value); err != nil || swapped {
+			return
+		}
+		if i >= maxRetries {
+			return nil, ErrCASConflict
+		}
+	}
+}
+
+// 只返回存在的 key
+func (namespace *Namespace) BatchGet(ctx context.Context, keys [][]byte) (entries []Entry, err error) {
+	for _, chunk := range namespace.chunks(len(keys), func(i int) int { return len(keys[i]) }) {
+		prefixed := make([][]byte, 0, chunk[1]-chunk[0])
+		for _, key := range keys[chunk[0]:chunk[1]] {
+			prefixed = append(prefixed, namespace.key(key))
+		}
+		var values [][]byte
+		if values, err = namespace.client.BatchGet(ctx, prefixed); err != nil {
+			return
+		}
+		for i, value := range values {
+			if value != nil {
+				entries = append(entries, Entry{Key: keys[chunk[0]+i], Value: value})
+			}
+		}
+	}
+	return
+}
+
+// 拆分为多批 每批内 使用 Entry 的 TTL 失败时 之前的批次已写入
+func (namespace *Namespace) BatchPut(ctx context.Context, entries []Entry) (err error) {
+	for _, chunk := range namespace.chunks(len(entries), func(i int) int { return len(entries[i].Key) + len(entries[i].Value) }) {
+		keys := make([][]byte, 0, chunk[1]-chunk[0])
+		values := make([][]byte, 0, chunk[1]-chunk[0])
+		ttls := make([]uint64, 0, chunk[1]-chunk[0])
+		var withTTL bool
+		for _, entry := range entries[chunk[0]:chunk[1]] {
+			keys = append(keys, namespace.key(entry.Key))
+			values = append(values, entry.Value)
+			ttls = append(ttls, ttlSeconds(entry.TTL))
+			withTTL = withTTL || entry.TTL > 0
+		}
+		if withTTL {
+			err = namespace.client.BatchPutWithTTL(ctx, keys, values, ttls)
+		} else {
+			err = namespace.client.BatchPut(ctx, keys, values)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (namespace *Namespace) BatchDelete(ctx context.Context, keys [][]byte) (err error) {
+	for _, chunk := range namespace.chunks(len(keys), func(i int) int { return len(keys[i]) }) {
+		prefixed := make([][]byte, 0, chunk[1]-chunk[0])
+		for _, key := range keys[chunk[0]:chunk[1]] {
+			prefixed = append(prefixed, namespace.key(key))
+		}
+		if err = namespace.client.BatchDelete(ctx, prefixed); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 遍历 namespace 中 prefix 开头的 key
+func (namespace *Namespace) Scan(prefix []byte) *Iterator {
+	start, end := namespace.bounds(prefix)
+	return &Iterator{
+		namespace: namespace,
+		start:     start,
+		end:       end,
+	}
+}
+
+func (iterator *Iterator) Next(ctx context.Context) bool {
+	if iterator.index+1 < len(iterator.pairs) {
+		iterator.index++
+		return true
+	}
+	if iterator.done || iterator.err != nil {
+		return false
+	}
+
+	limit := iterator.namespace.PageSize
+	if limit <= 0 || limit > rawkv.MaxRawKVScanLimit {
+		limit = rawkv.MaxRawKVScanLimit
+	}
+	keys, values, err := iterator.namespace.client.Scan(ctx, iterator.start, iterator.end, limit)
+	if err != nil {
+		iterator.err = err
+		return false
+	}
+	if len(keys) < limit {
+		iterator.done = true
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	iterator.pairs = iterator.pairs[:0]
+	for i, key := range keys {
+		iterator.pairs = append(iterator.pairs, Entry{Key: key[len(iterator.namespace.prefix):], Value: values[i]})
+	}
+	iterator.index = 0
+	iterator.start = append(append([]byte{}, keys[len(keys)-1]...), 0)
+	return true
+}
+
+func (iterator *Iterator) Key() []byte {
+	return iterator.pairs[iterator.index].Key
+}
+
+func (iterator *Iterator) Value() []byte {
+	return iterator.pairs[iterator.index].Value
+}
+
+func (iterator *Iterator) Err() error {
+	return iterator.err
+}
+
+func (namespace *Namespace) key(key []byte) []byte {
+	b := make([]byte, 0, len(namespace.prefix)+len(key))
+	b = append(b, namespace.prefix...)
+	return append(b, key...)
+}
+
+func (namespace *Namespace) bounds(prefix []byte) (start []byte, end []byte) {
+	start = namespace.key(prefix)
+	end = append([]byte{}, start...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return start, end[:i+1]
+		}
+	}
+	// 前缀 全部为 0xff 或 为空 时 不限制结束
+	return start, nil
+}
+
+// 按数量 和 字节数 拆分 返回 [start, end) 列表
+func (namespace *Namespace) chunks(n int, size func(i int) int) (chunks [][2]int) {
+	maxKeys := namespace.MaxBatchKeys
+	if maxKeys <= 0 {
+		maxKeys = n
+	}
+	start, total := 0, 0
+	for i := 0; i < n; i++ {
+		s := size(i)
+		if i > start && (i-start >= maxKeys || (namespace.MaxBatchBytes > 0 && total+s > namespace.MaxBatchBytes)) {
+			chunks = append(chunks, [2]int{start, i})
+			start, total = i, 0
+		}
+		total += s
+	}
+	if start < n {
+		chunks = append(chunks, [2]int{start, n})
+	}
+	return
+}
+
+// 不足一秒 按一秒
+func ttlSeconds(ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint64((ttl + time.Second - 1) / time.Second)
+}
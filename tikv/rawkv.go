@@ -2,14 +2,8 @@ package libtikv
 
 import (
 	"context"
-	"fmt"
-	"io/ioutil"
-	"os"
-	"path"
 
-	libutils "github.com/otamoe/go-library/utils"
 	"github.com/spf13/viper"
-	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/rawkv"
 	pd "github.com/tikv/pd/client"
 	"go.uber.org/fx"
@@ -18,13 +12,19 @@ import (
 type (
 	InRawkvOptions struct {
 		fx.In
-		Options []pd.ClientOption `group:"tikvRawkvOptions"`
+		Options      []pd.ClientOption `group:"tikvRawkvOptions"`
+		TLSProviders []TLSProvider     `group:"tikvRawkvTLSProviders"`
 	}
 
 	OutRawkvOption struct {
 		fx.Out
 		Option pd.ClientOption `group:"tikvRawkvOptions"`
 	}
+
+	OutRawkvTLSProvider struct {
+		fx.Out
+		Provider TLSProvider `group:"tikvRawkvTLSProviders"`
+	}
 )
 
 func RawtvOption(option pd.ClientOption) func() (out OutRawkvOption) {
@@ -34,40 +34,51 @@ func RawtvOption(option pd.ClientOption) func() (out OutRawkvOption) {
 	}
 }
 
-func Rawkv(ctx context.Context, lc fx.Lifecycle, inRawkvOptions InRawkvOptions) (client *rawkv.Client, err error) {
-
-	security := config.Security{
-		ClusterVerifyCN: viper.GetStringSlice("tikv.rawkv.tls.cn"),
-	}
-
-	rand := string(libutils.RandByte(8, libutils.RandAlphaNumber))
-
-	// ca 文件
-	security.ClusterSSLCA = path.Join(os.TempDir(), fmt.Sprintf("tikv-rawkv-tls-ca-%s", rand))
-	if err = ioutil.WriteFile(security.ClusterSSLCA, []byte(viper.GetString("tikv.rawkv.tls.ca")), 0755); err != nil {
+// 内存中的证书 优先于 viper 配置
+func RawkvTLSProvider(provider TLSProvider) func() (out OutRawkvTLSProvider) {
+	return func() (out OutRawkvTLSProvider) {
+		out.Provider = provider
 		return
 	}
-	// cert 文件
-	security.ClusterSSLCert = path.Join(os.TempDir(), fmt.Sprintf("tikv-rawkv-tls-cert-%s", rand))
-	if err = ioutil.WriteFile(security.ClusterSSLCert, []byte(viper.GetString("tikv.rawkv.tls.cert")), 0755); err != nil {
+}
+
+func Rawkv(ctx context.Context, lc fx.Lifecycle, inRawkvOptions InRawkvOptions) (client *rawkv.Client, err error) {
+	var clientConfig *ClientConfig
+	if clientConfig, err = NewClientConfig("rawkv"); err != nil {
 		return
 	}
 
-	// key 文件
-	security.ClusterSSLKey = path.Join(os.TempDir(), fmt.Sprintf("tikv-rawkv-tls-key-%s", rand))
-	if err = ioutil.WriteFile(security.ClusterSSLKey, []byte(viper.GetString("tikv.rawkv.tls.key")), 0755); err != nil {
+	var t *TLS
+	if t, err = NewTLS(ctx, "rawkv", inRawkvOptions.TLSProviders); err != nil {
 		return
 	}
 
-	if client, err = rawkv.NewClient(ctx, viper.GetStringSlice("tikv.rawkv.pdAddress"), security, inRawkvOptions.Options...); err != nil {
+	release := acquireGlobalConfig(t.Security)
+	if client, err = rawkv.NewClientWithOpts(
+		ctx,
+		clientConfig.PDAddress,
+		rawkv.WithSecurity(t.Security),
+		rawkv.WithAPIVersion(clientConfig.APIVersion),
+		rawkv.WithGRPCDialOptions(clientConfig.DialOptions()...),
+		rawkv.WithPDOptions(append(clientConfig.PDOptions(), inRawkvOptions.Options...)...),
+	); err != nil {
+		release()
+		t.Close()
 		return
 	}
+	client.SetAtomicForCAS(viper.GetBool("tikv.rawkv.atomicForCAS"))
+
+	watchCtx, cancel := context.WithCancel(context.Background())
 	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			go t.Watch(watchCtx, viper.GetDuration("tikv.rawkv.tls.reloadInterval"))
+			return nil
+		},
 		OnStop: func(c context.Context) error {
+			cancel()
 			client.Close()
-			os.Remove(security.ClusterSSLCA)
-			os.Remove(security.ClusterSSLCert)
-			os.Remove(security.ClusterSSLKey)
+			t.Close()
+			release()
 			return nil
 		},
 	})
@@ -1,6 +1,8 @@
 package libtikv
 
 import (
+	"time"
+
 	libviper "github.com/otamoe/go-library/viper"
 	"go.uber.org/fx"
 )
@@ -14,6 +16,10 @@ func New() fx.Option {
 	return fx.Options(
 		fx.Provide(Rawkv),
 		fx.Provide(Txnkv),
+		fx.Provide(NewRawkvClient),
+		fx.Provide(NewTxnkvClient),
+		fx.Provide(RawkvHealthChecker),
+		fx.Provide(TxnkvHealthChecker),
 	)
 }
 
@@ -23,9 +29,36 @@ func init() {
 	libviper.SetDefault("tikv.rawkv.tls.cert", "", "tikv rawkv tls cert")
 	libviper.SetDefault("tikv.rawkv.tls.key", "", "tikv rawkv tls key")
 	libviper.SetDefault("tikv.rawkv.tls.cn", []string{}, "tikv rawkv tls cn")
+	libviper.SetDefault("tikv.rawkv.tls.caFile", "", "tikv rawkv tls ca file path")
+	libviper.SetDefault("tikv.rawkv.tls.certFile", "", "tikv rawkv tls cert file path")
+	libviper.SetDefault("tikv.rawkv.tls.keyFile", "", "tikv rawkv tls key file path")
+	libviper.SetDefault("tikv.rawkv.tls.reloadInterval", time.Minute, "tikv rawkv tls reload interval")
 	libviper.SetDefault("tikv.txnkv.pdAddress", []string{"127.0.0.1:2379"}, "tikv txnkv pb address")
 	libviper.SetDefault("tikv.txnkv.tls.ca", "", "tikv txnkv tls ca")
 	libviper.SetDefault("tikv.txnkv.tls.cert", "", "tikv txnkv tls cert")
 	libviper.SetDefault("tikv.txnkv.tls.key", "", "tikv txnkv tls key")
 	libviper.SetDefault("tikv.txnkv.tls.cn", []string{}, "tikv txnkv tls cn")
+	libviper.SetDefault("tikv.txnkv.tls.caFile", "", "tikv txnkv tls ca file path")
+	libviper.SetDefault("tikv.txnkv.tls.certFile", "", "tikv txnkv tls cert file path")
+	libviper.SetDefault("tikv.txnkv.tls.keyFile", "", "tikv txnkv tls key file path")
+	libviper.SetDefault("tikv.txnkv.tls.reloadInterval", time.Minute, "tikv txnkv tls reload interval")
+	libviper.SetDefault("tikv.rawkv.apiVersion", "v1", "tikv rawkv api version  v1, v1ttl, v2")
+	libviper.SetDefault("tikv.rawkv.grpc.keepAliveTime", time.Second*10, "tikv rawkv grpc keepalive time")
+	libviper.SetDefault("tikv.rawkv.grpc.keepAliveTimeout", time.Second*3, "tikv rawkv grpc keepalive timeout")
+	libviper.SetDefault("tikv.rawkv.pdTimeout", time.Second*3, "tikv rawkv pd request timeout")
+	libviper.SetDefault("tikv.rawkv.keyPrefix", "", "tikv rawkv key prefix, used by RawkvClient and libkv")
+	libviper.SetDefault("tikv.txnkv.apiVersion", "v1", "tikv txnkv api version  v1, v1ttl, v2")
+	libviper.SetDefault("tikv.txnkv.grpc.keepAliveTime", time.Second*10, "tikv txnkv grpc keepalive time")
+	libviper.SetDefault("tikv.txnkv.grpc.keepAliveTimeout", time.Second*3, "tikv txnkv grpc keepalive timeout")
+	libviper.SetDefault("tikv.txnkv.pdTimeout", time.Second*3, "tikv txnkv pd request timeout")
+	libviper.SetDefault("tikv.txnkv.keyPrefix", "", "tikv txnkv key prefix, used by TxnkvClient and libkv")
+	libviper.SetDefault("tikv.rawkv.atomicForCAS", false, "tikv rawkv atomic mode, required by compare and swap, must match on all clients")
+	libviper.SetDefault("tikv.txnkv.asyncCommit", false, "tikv txnkv enable async commit, used by TxnkvClient, RunInTxn and libkv")
+	libviper.SetDefault("tikv.txnkv.onePC", false, "tikv txnkv enable one phase commit, used by TxnkvClient, RunInTxn and libkv")
+	libviper.SetDefault("tikv.global.grpcConnectionCount", 4, "tikv grpc connection count per store, shared by all clients")
+	libviper.SetDefault("tikv.global.grpcKeepAliveTime", time.Second*10, "tikv grpc keepalive time for txnkv store connections, shared by all clients")
+	libviper.SetDefault("tikv.global.grpcKeepAliveTimeout", time.Second*3, "tikv grpc keepalive timeout for txnkv store connections, shared by all clients")
+	libviper.SetDefault("tikv.global.commitTimeout", time.Second*41, "tikv commit timeout, shared by all clients")
+	libviper.SetDefault("tikv.global.enableForwarding", false, "tikv forward requests through other stores when a store is unreachable, shared by all clients")
+	libviper.SetDefault("tikv.global.txnLocalLatches", 0, "tikv txnkv local latches capacity, 0 disabled, shared by all clients")
 }
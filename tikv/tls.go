@@ -0,0 +1,263 @@
+package libtikv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	liblogger "github.com/otamoe/go-library/logger"
+	"github.com/spf13/viper"
+	"github.com/tikv/client-go/v2/config"
+	"go.uber.org/zap"
+)
+
+type (
+	// PEM 格式
+	TLSMaterial struct {
+		CA   []byte
+		Cert []byte
+		Key  []byte
+	}
+
+	// 内存中的证书来源 定时调用 内容变化时 重写文件
+	TLSProvider func(ctx context.Context) (material TLSMaterial, err error)
+
+	// tikv 客户端只接受文件路径 内存中的证书 写入进程私有目录
+	// cert key 每次握手都会重新读取 所以重写文件即可生效 ca 只在创建客户端时读取 变化后 需要重启
+	TLS struct {
+		Security config.Security
+
+		name      string
+		provider  TLSProvider
+		dir       string
+		writeCA   bool
+		writeCert bool
+
+		mux      sync.Mutex
+		material TLSMaterial
+	}
+)
+
+const tlsDirPrefix = "tikv-tls-"
+
+var ErrTLSIncomplete = errors.New("tikv tls cert and key must be set together")
+var ErrTLSRestartRequired = errors.New("tikv tls ca changed, restart required")
+
+// name 为 rawkv 或 txnkv 读取 tikv.{name}.tls.*
+// providers 不为空时 使用最后一个 否则 caFile certFile keyFile 直接使用 ca cert key 写入文件
+func NewTLS(ctx context.Context, name string, providers []TLSProvider) (t *TLS, err error) {
+	prefix := "tikv." + name + ".tls."
+	t = &TLS{
+		name: name,
+		Security: config.Security{
+			ClusterVerifyCN: viper.GetStringSlice(prefix + "cn"),
+		},
+	}
+
+	if len(providers) != 0 {
+		t.provider = providers[len(providers)-1]
+	} else {
+		t.Security.ClusterSSLCA = viper.GetString(prefix + "caFile")
+		t.Security.ClusterSSLCert = viper.GetString(prefix + "certFile")
+		t.Security.ClusterSSLKey = viper.GetString(prefix + "keyFile")
+		if (t.Security.ClusterSSLCert == "") != (t.Security.ClusterSSLKey == "") {
+			err = ErrTLSIncomplete
+			return
+		}
+		// 没有文件路径 的部分 使用内联 PEM
+		caFile, certFile := t.Security.ClusterSSLCA, t.Security.ClusterSSLCert
+		t.provider = func(ctx context.Context) (material TLSMaterial, err error) {
+			if caFile == "" {
+				material.CA = []byte(viper.GetString(prefix + "ca"))
+			}
+			if certFile == "" {
+				material.Cert = []byte(viper.GetString(prefix + "cert"))
+				material.Key = []byte(viper.GetString(prefix + "key"))
+			}
+			return
+		}
+	}
+
+	var material TLSMaterial
+	if material, err = t.provider(ctx); err != nil {
+		return
+	}
+	if (len(material.Cert) == 0) != (len(material.Key) == 0) {
+		err = ErrTLSIncomplete
+		return
+	}
+	if len(material.CA) == 0 && len(material.Cert) == 0 {
+		// 全部使用文件路径 或 不使用 tls
+		t.provider = nil
+		return
+	}
+
+	// 清理 已退出进程 遗留的目录
+	SweepTLSDirs()
+
+	if t.dir, err = os.MkdirTemp("", fmt.Sprintf("%s%d-", tlsDirPrefix, os.Getpid())); err != nil {
+		return
+	}
+	if err = os.Chmod(t.dir, 0700); err != nil {
+		t.Close()
+		return
+	}
+	if t.writeCA = len(material.CA) != 0; t.writeCA {
+		t.Security.ClusterSSLCA = filepath.Join(t.dir, name+"-ca.pem")
+	}
+	if t.writeCert = len(material.Cert) != 0; t.writeCert {
+		t.Security.ClusterSSLCert = filepath.Join(t.dir, name+"-cert.pem")
+		t.Security.ClusterSSLKey = filepath.Join(t.dir, name+"-key.pem")
+	}
+	if err = t.write(material); err != nil {
+		t.Close()
+		return
+	}
+	return
+}
+
+// 读取来源 有变化时 重写文件 ca 变化时 返回 ErrTLSRestartRequired cert key 仍然生效
+func (t *TLS) Reload(ctx context.Context) (changed bool, err error) {
+	if t.provider == nil {
+		return
+	}
+	var material TLSMaterial
+	if material, err = t.provider(ctx); err != nil {
+		return
+	}
+	t.mux.Lock()
+	caChanged := !bytes.Equal(material.CA, t.material.CA)
+	changed = caChanged || !bytes.Equal(material.Cert, t.material.Cert) || !bytes.Equal(material.Key, t.material.Key)
+	t.mux.Unlock()
+	if !changed {
+		return
+	}
+	// 文件集合 在创建时已确定 不允许 新增 或 删除
+	if t.writeCA != (len(material.CA) != 0) || t.writeCert != (len(material.Cert) != 0) || t.writeCert != (len(material.Key) != 0) {
+		err = ErrTLSIncomplete
+		return
+	}
+	if err = t.write(material); err != nil {
+		return
+	}
+	if caChanged {
+		err = ErrTLSRestartRequired
+	}
+	return
+}
+
+// 定时重新加载 直到 ctx 结束
+func (t *TLS) Watch(ctx context.Context, interval time.Duration) {
+	if t.provider == nil || interval <= 0 {
+		return
+	}
+	logger := liblogger.Get("tikv." + t.name + ".tls")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if changed, err := t.Reload(ctx); errors.Is(err, ErrTLSRestartRequired) {
+			logger.Warn("reload", zap.Error(err))
+		} else if err != nil {
+			logger.Error("reload", zap.Error(err))
+		} else if changed {
+			logger.Info("reloaded")
+		}
+	}
+}
+
+// 删除 私有目录
+func (t *TLS) Close() error {
+	if t.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(t.dir)
+}
+
+func (t *TLS) write(material TLSMaterial) (err error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.writeCA {
+		if err = writeFileAtomic(t.Security.ClusterSSLCA, material.CA); err != nil {
+			return
+		}
+	}
+	if t.writeCert {
+		// 先写 key 再写 cert 两者不一致时 握手失败 下次重试即可
+		if err = writeFileAtomic(t.Security.ClusterSSLKey, material.Key); err != nil {
+			return
+		}
+		if err = writeFileAtomic(t.Security.ClusterSSLCert, material.Cert); err != nil {
+			return
+		}
+	}
+	t.material = material
+	return
+}
+
+func writeFileAtomic(name string, data []byte) (err error) {
+	var f *os.File
+	if f, err = os.CreateTemp(filepath.Dir(name), ".tmp-*"); err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	if err = f.Chmod(0600); err != nil {
+		f.Close()
+		return
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// 删除 临时目录中 进程已不存在 的 tls 目录 进程崩溃 或 被 kill 时遗留
+func SweepTLSDirs() {
+	entries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tlsDirPrefix) {
+			continue
+		}
+		s := strings.TrimPrefix(entry.Name(), tlsDirPrefix)
+		if i := strings.IndexByte(s, '-'); i != -1 {
+			s = s[:i]
+		}
+		pid, err := strconv.Atoi(s)
+		if err != nil || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		os.RemoveAll(filepath.Join(os.TempDir(), entry.Name()))
+	}
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || !(errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH))
+}
@@ -0,0 +1,234 @@
+package libtikv
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	liblogger "github.com/otamoe/go-library/logger"
+	"github.com/spf13/viper"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/kv"
+	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/txnkv/transaction"
+	"github.com/tikv/client-go/v2/txnkv/txnsnapshot"
+	"go.uber.org/zap"
+)
+
+type (
+	TxnOptions struct {
+		Name        string
+		Pessimistic bool
+		AsyncCommit bool
+		OnePC       bool
+		MaxRetries  int
+		Backoff     time.Duration
+		MaxBackoff  time.Duration
+	}
+
+	TxnOption func(txnOptions *TxnOptions)
+
+	TxnErrorKind int
+)
+
+const (
+	TxnErrorNone TxnErrorKind = iota
+	// 写冲突 死锁 可重试
+	TxnErrorConflict
+	// 锁等待 锁清理 服务繁忙 可重试
+	TxnErrorLock
+	// 提交结果未知 不能重试
+	TxnErrorUndetermined
+	TxnErrorFatal
+)
+
+func (kind TxnErrorKind) String() string {
+	switch kind {
+	case TxnErrorNone:
+		return "none"
+	case TxnErrorConflict:
+		return "conflict"
+	case TxnErrorLock:
+		return "lock"
+	case TxnErrorUndetermined:
+		return "undetermined"
+	default:
+		return "fatal"
+	}
+}
+
+func (kind TxnErrorKind) Retryable() bool {
+	return kind == TxnErrorConflict || kind == TxnErrorLock
+}
+
+// 用于日志
+func WithTxnName(name string) TxnOption {
+	return func(txnOptions *TxnOptions) {
+		txnOptions.Name = name
+	}
+}
+
+// 悲观事务 写入前需要 LockKeys
+func WithPessimistic() TxnOption {
+	return func(txnOptions *TxnOptions) {
+		txnOptions.Pessimistic = true
+	}
+}
+
+// 默认 读取 tikv.txnkv.asyncCommit tikv.txnkv.onePC
+func WithCommitMode(asyncCommit bool, onePC bool) TxnOption {
+	return func(txnOptions *TxnOptions) {
+		txnOptions.AsyncCommit = asyncCommit
+		txnOptions.OnePC = onePC
+	}
+}
+
+func WithTxnRetry(maxRetries int, backoff time.Duration, maxBackoff time.Duration) TxnOption {
+	return func(txnOptions *TxnOptions) {
+		txnOptions.MaxRetries = maxRetries
+		txnOptions.Backoff = backoff
+		txnOptions.MaxBackoff = maxBackoff
+	}
+}
+
+func ClassifyTxnError(err error) TxnErrorKind {
+	var deadlock *tikverr.ErrDeadlock
+	var latch *tikverr.ErrWriteConflictInLatch
+	var retryable *tikverr.ErrRetryable
+	var pdTimeout *tikverr.ErrPDServerTimeout
+	switch {
+	case err == nil:
+		return TxnErrorNone
+	case tikverr.IsErrorUndetermined(err):
+		return TxnErrorUndetermined
+	case tikverr.IsErrWriteConflict(err), errors.As(err, &deadlock), errors.As(err, &latch), errors.As(err, &retryable):
+		return TxnErrorConflict
+	case errors.Is(err, tikverr.ErrLockWaitTimeout),
+		errors.Is(err, tikverr.ErrResolveLockTimeout),
+		errors.Is(err, tikverr.ErrLockAcquireFailAndNoWaitSet),
+		errors.Is(err, tikverr.ErrTiKVServerBusy),
+		errors.Is(err, tikverr.ErrTiKVServerTimeout),
+		errors.Is(err, tikverr.ErrRegionUnavailable),
+		errors.As(err, &pdTimeout):
+		return TxnErrorLock
+	default:
+		return TxnErrorFatal
+	}
+}
+
+// 执行事务 fn 返回错误时回滚 冲突 和 锁错误 指数退避后重试
+// fn 可能执行多次 不要在 fn 中产生外部副作用
+func RunInTxn(ctx context.Context, client *txnkv.Client, fn func(txn *transaction.KVTxn) error, opts ...TxnOption) (err error) {
+	txnOptions := newTxnOptions(opts)
+	mode := "optimistic"
+	if txnOptions.Pessimistic {
+		mode = "pessimistic"
+	}
+	return runWithRetry(ctx, txnOptions, mode, func() (startTS uint64, err error) {
+		var txn *transaction.KVTxn
+		if txn, err = client.Begin(); err != nil {
+			return
+		}
+		startTS = txn.StartTS()
+		txn.SetPessimistic(txnOptions.Pessimistic)
+		txn.SetEnableAsyncCommit(txnOptions.AsyncCommit)
+		txn.SetEnable1PC(txnOptions.OnePC)
+		if err = fn(txn); err != nil {
+			txn.Rollback()
+			return
+		}
+		if err = txn.Commit(ctx); err != nil && !tikverr.IsErrorUndetermined(err) {
+			txn.Rollback()
+		}
+		return
+	})
+}
+
+// 只读 快照读取
+func RunInSnapshot(ctx context.Context, client *txnkv.Client, fn func(snapshot *txnsnapshot.KVSnapshot) error, opts ...TxnOption) (err error) {
+	return runWithRetry(ctx, newTxnOptions(opts), "snapshot", func() (startTS uint64, err error) {
+		if startTS, err = client.GetTimestamp(ctx); err != nil {
+			return
+		}
+		return startTS, fn(client.GetSnapshot(startTS))
+	})
+}
+
+// 悲观事务 中锁定 key lockWait 为 0 时不等待 锁被占用时返回 TxnErrorLock 类错误
+func LockKeys(ctx context.Context, txn *transaction.KVTxn, lockWait time.Duration, keys ...[]byte) error {
+	wait := kv.LockNoWait
+	if lockWait > 0 {
+		wait = lockWait.Milliseconds()
+	}
+	return txn.LockKeys(ctx, kv.NewLockCtx(txn.StartTS(), wait, time.Now()), keys...)
+}
+
+func newTxnOptions(opts []TxnOption) *TxnOptions {
+	txnOptions := &TxnOptions{
+		Name:        "txn",
+		MaxRetries:  10,
+		Backoff:     time.Millisecond * 10,
+		MaxBackoff:  time.Second,
+		AsyncCommit: viper.GetBool("tikv.txnkv.asyncCommit"),
+		OnePC:       viper.GetBool("tikv.txnkv.onePC"),
+	}
+	for _, o := range opts {
+		o(txnOptions)
+	}
+	return txnOptions
+}
+
+func runWithRetry(ctx context.Context, txnOptions *TxnOptions, mode string, fn func() (startTS uint64, err error)) (err error) {
+	logger := liblogger.Get("tikv.txn")
+	start := time.Now()
+	backoff := txnOptions.Backoff
+	var startTS uint64
+	var retries int
+	for retries = 0; ; retries++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		startTS, err = fn()
+		kind := ClassifyTxnError(err)
+		if !kind.Retryable() || retries >= txnOptions.MaxRetries {
+			break
+		}
+		logger.Warn(
+			"retry",
+			zap.String("name", txnOptions.Name),
+			zap.String("mode", mode),
+			zap.Uint64("startTS", startTS),
+			zap.Int("retry", retries+1),
+			zap.String("kind", kind.String()),
+			zap.Error(err),
+		)
+
+		// 随机 抖动
+		sleep := backoff
+		if backoff > 0 {
+			sleep += time.Duration(rand.Int63n(int64(backoff)))
+		}
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+		}
+		if backoff *= 2; backoff > txnOptions.MaxBackoff {
+			backoff = txnOptions.MaxBackoff
+		}
+	}
+
+	fields := []zap.Field{
+		zap.String("name", txnOptions.Name),
+		zap.String("mode", mode),
+		zap.Uint64("startTS", startTS),
+		zap.Int("retries", retries),
+		zap.Duration("latency", time.Since(start)),
+	}
+	if err != nil {
+		logger.Error("failed", append(fields, zap.String("kind", ClassifyTxnError(err).String()), zap.Error(err))...)
+	} else {
+		logger.Debug("committed", fields...)
+	}
+	return
+}
@@ -2,16 +2,15 @@ package libtikv
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path"
 
-	libutils "github.com/otamoe/go-library/utils"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/spf13/viper"
 	"github.com/tikv/client-go/v2/config"
-
+	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/util"
 	pd "github.com/tikv/pd/client"
 	"go.uber.org/fx"
 )
@@ -19,13 +18,19 @@ import (
 type (
 	InTxnkvOptions struct {
 		fx.In
-		Options []pd.ClientOption `group:"tikvTxnkvOptions"`
+		Options      []pd.ClientOption `group:"tikvTxnkvOptions"`
+		TLSProviders []TLSProvider     `group:"tikvTxnkvTLSProviders"`
 	}
 
 	OutTxnkvOption struct {
 		fx.Out
 		Option pd.ClientOption `group:"tikvTxnkvOptions"`
 	}
+
+	OutTxnkvTLSProvider struct {
+		fx.Out
+		Provider TLSProvider `group:"tikvTxnkvTLSProviders"`
+	}
 )
 
 func TxntvOption(option pd.ClientOption) func() (out OutTxnkvOption) {
@@ -35,48 +40,89 @@ func TxntvOption(option pd.ClientOption) func() (out OutTxnkvOption) {
 	}
 }
 
-func Txnkv(ctx context.Context, lc fx.Lifecycle, inTxnkvOptions InTxnkvOptions) (client *txnkv.Client, err error) {
-	security := config.Security{
-		ClusterVerifyCN: viper.GetStringSlice("tikv.txnkv.tls.cn"),
+// 内存中的证书 优先于 viper 配置
+func TxnkvTLSProvider(provider TLSProvider) func() (out OutTxnkvTLSProvider) {
+	return func() (out OutTxnkvTLSProvider) {
+		out.Provider = provider
+		return
 	}
+}
 
-	rand := string(libutils.RandByte(8, libutils.RandAlphaNumber))
-
-	// ca 文件
-	security.ClusterSSLCA = path.Join(os.TempDir(), fmt.Sprintf("tikv-txnkv-tls-ca-%s", rand))
-	if err = ioutil.WriteFile(security.ClusterSSLCA, []byte(viper.GetString("tikv.txnkv.tls.ca")), 0755); err != nil {
+func Txnkv(ctx context.Context, lc fx.Lifecycle, inTxnkvOptions InTxnkvOptions) (client *txnkv.Client, err error) {
+	var clientConfig *ClientConfig
+	if clientConfig, err = NewClientConfig("txnkv"); err != nil {
 		return
 	}
-	// cert 文件
-	security.ClusterSSLCert = path.Join(os.TempDir(), fmt.Sprintf("tikv-txnkv-tls-cert-%s", rand))
-	if err = ioutil.WriteFile(security.ClusterSSLCert, []byte(viper.GetString("tikv.txnkv.tls.cert")), 0755); err != nil {
+	if clientConfig.APIVersion != kvrpcpb.APIVersion_V1 {
+		err = fmt.Errorf("tikv txnkv api version %s not supported", clientConfig.APIVersion)
 		return
 	}
 
-	// key 文件
-	security.ClusterSSLKey = path.Join(os.TempDir(), fmt.Sprintf("tikv-txnkv-tls-key-%s", rand))
-	if err = ioutil.WriteFile(security.ClusterSSLKey, []byte(viper.GetString("tikv.txnkv.tls.key")), 0755); err != nil {
+	var t *TLS
+	if t, err = NewTLS(ctx, "txnkv", inTxnkvOptions.TLSProviders); err != nil {
 		return
 	}
 
-	cfg := config.GetGlobalConfig()
-	cfg.Security = security
-
-	config.StoreGlobalConfig(cfg)
-
-	if client, err = txnkv.NewClient(viper.GetStringSlice("tikv.txnkv.pdAddress")); err != nil {
+	release := acquireGlobalConfig(t.Security)
+	if client, err = newTxnkvClient(ctx, clientConfig, t.Security, inTxnkvOptions.Options); err != nil {
+		release()
+		t.Close()
 		return
 	}
 
+	watchCtx, cancel := context.WithCancel(context.Background())
 	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			go t.Watch(watchCtx, viper.GetDuration("tikv.txnkv.tls.reloadInterval"))
+			return nil
+		},
 		OnStop: func(c context.Context) error {
-			os.Remove(security.ClusterSSLCA)
-			os.Remove(security.ClusterSSLCert)
-			os.Remove(security.ClusterSSLKey)
+			cancel()
 			client.Close()
+			t.Close()
+			release()
 			return nil
 		},
 	})
 
 	return
 }
+
+// 与 txnkv.NewClient 相同 但 security 和 pd 选项 使用客户端自己的配置
+func newTxnkvClient(ctx context.Context, clientConfig *ClientConfig, security config.Security, options []pd.ClientOption) (client *txnkv.Client, err error) {
+	cfg := config.GetGlobalConfig()
+	var pdCli pd.Client
+	if pdCli, err = pd.NewClient(clientConfig.PDAddress, pd.SecurityOption{
+		CAPath:   security.ClusterSSLCA,
+		CertPath: security.ClusterSSLCert,
+		KeyPath:  security.ClusterSSLKey,
+	}, append(append(clientConfig.PDOptions(), pd.WithForwardingOption(cfg.EnableForwarding)), options...)...); err != nil {
+		return
+	}
+	// region 边界 为 memcomparable 编码 需要与 client-go 相同的包装 否则 key 定位到错误的 region
+	pdClient := &tikv.CodecPDClient{Client: util.InterceptedPDClient{Client: pdCli}}
+
+	var tlsConfig *tls.Config
+	if tlsConfig, err = security.ToTLSConfig(); err != nil {
+		pdClient.Close()
+		return
+	}
+
+	var spkv *tikv.EtcdSafePointKV
+	if spkv, err = tikv.NewEtcdSafePointKV(clientConfig.PDAddress, tlsConfig); err != nil {
+		pdClient.Close()
+		return
+	}
+
+	var store *tikv.KVStore
+	if store, err = tikv.NewKVStore(fmt.Sprintf("tikv-%v", pdClient.GetClusterID(ctx)), pdClient, spkv, tikv.NewRPCClient(tikv.WithSecurity(security))); err != nil {
+		spkv.Close()
+		pdClient.Close()
+		return
+	}
+	if cfg.TxnLocalLatches.Enabled {
+		store.EnableTxnLocalLatches(cfg.TxnLocalLatches.Capacity)
+	}
+	client = &txnkv.Client{KVStore: store}
+	return
+}